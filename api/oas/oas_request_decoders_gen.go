@@ -7,7 +7,6 @@ import (
 	"net/http"
 
 	"github.com/go-faster/errors"
-
 	"github.com/ogen-go/ogen/conv"
 	ht "github.com/ogen-go/ogen/http"
 	"github.com/ogen-go/ogen/uri"
@@ -16,6 +15,7 @@ import (
 
 func (s *Server) decodeExchangeTokenRequest(r *http.Request) (
 	req *TokenExchangeRequest,
+	rawBody []byte,
 	close func() error,
 	rerr error,
 ) {
@@ -36,16 +36,16 @@ func (s *Server) decodeExchangeTokenRequest(r *http.Request) (
 	}()
 	ct, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
 	if err != nil {
-		return req, close, errors.Wrap(err, "parse media type")
+		return req, rawBody, close, errors.Wrap(err, "parse media type")
 	}
 	switch {
 	case ct == "application/x-www-form-urlencoded":
 		if r.ContentLength == 0 {
-			return req, close, validate.ErrBodyRequired
+			return req, rawBody, close, validate.ErrBodyRequired
 		}
 		form, err := ht.ParseForm(r)
 		if err != nil {
-			return req, close, errors.Wrap(err, "parse form")
+			return req, rawBody, close, errors.Wrap(err, "parse form")
 		}
 
 		var request TokenExchangeRequest
@@ -73,7 +73,7 @@ func (s *Server) decodeExchangeTokenRequest(r *http.Request) (
 
 		for k := range form {
 			if !defined(k) {
-				return req, close, errors.Errorf("unexpected field %q", k)
+				return req, rawBody, close, errors.Errorf("unexpected field %q", k)
 			}
 		}
 		q := uri.NewQueryDecoder(form)
@@ -98,7 +98,7 @@ func (s *Server) decodeExchangeTokenRequest(r *http.Request) (
 					request.GrantType = TokenExchangeRequestGrantType(c)
 					return nil
 				}); err != nil {
-					return req, close, errors.Wrap(err, "decode \"grant_type\"")
+					return req, rawBody, close, errors.Wrap(err, "decode \"grant_type\"")
 				}
 				if err := func() error {
 					if err := request.GrantType.Validate(); err != nil {
@@ -106,10 +106,10 @@ func (s *Server) decodeExchangeTokenRequest(r *http.Request) (
 					}
 					return nil
 				}(); err != nil {
-					return req, close, errors.Wrap(err, "validate")
+					return req, rawBody, close, errors.Wrap(err, "validate")
 				}
 			} else {
-				return req, close, errors.Wrap(err, "query")
+				return req, rawBody, close, errors.Wrap(err, "query")
 			}
 		}
 		{
@@ -142,7 +142,7 @@ func (s *Server) decodeExchangeTokenRequest(r *http.Request) (
 						return nil
 					})
 				}); err != nil {
-					return req, close, errors.Wrap(err, "decode \"audience\"")
+					return req, rawBody, close, errors.Wrap(err, "decode \"audience\"")
 				}
 			}
 		}
@@ -174,7 +174,7 @@ func (s *Server) decodeExchangeTokenRequest(r *http.Request) (
 					request.Scope.SetTo(requestDotScopeVal)
 					return nil
 				}); err != nil {
-					return req, close, errors.Wrap(err, "decode \"scope\"")
+					return req, rawBody, close, errors.Wrap(err, "decode \"scope\"")
 				}
 			}
 		}
@@ -199,10 +199,10 @@ func (s *Server) decodeExchangeTokenRequest(r *http.Request) (
 					request.SubjectToken = c
 					return nil
 				}); err != nil {
-					return req, close, errors.Wrap(err, "decode \"subject_token\"")
+					return req, rawBody, close, errors.Wrap(err, "decode \"subject_token\"")
 				}
 			} else {
-				return req, close, errors.Wrap(err, "query")
+				return req, rawBody, close, errors.Wrap(err, "query")
 			}
 		}
 		{
@@ -226,7 +226,7 @@ func (s *Server) decodeExchangeTokenRequest(r *http.Request) (
 					request.SubjectTokenType = TokenExchangeRequestSubjectTokenType(c)
 					return nil
 				}); err != nil {
-					return req, close, errors.Wrap(err, "decode \"subject_token_type\"")
+					return req, rawBody, close, errors.Wrap(err, "decode \"subject_token_type\"")
 				}
 				if err := func() error {
 					if err := request.SubjectTokenType.Validate(); err != nil {
@@ -234,14 +234,14 @@ func (s *Server) decodeExchangeTokenRequest(r *http.Request) (
 					}
 					return nil
 				}(); err != nil {
-					return req, close, errors.Wrap(err, "validate")
+					return req, rawBody, close, errors.Wrap(err, "validate")
 				}
 			} else {
-				return req, close, errors.Wrap(err, "query")
+				return req, rawBody, close, errors.Wrap(err, "query")
 			}
 		}
-		return &request, close, nil
+		return &request, rawBody, close, nil
 	default:
-		return req, close, validate.InvalidContentType(ct)
+		return req, rawBody, close, validate.InvalidContentType(ct)
 	}
 }
@@ -235,6 +235,93 @@ func (s *ExchangeTokenOKTokenType) UnmarshalText(data []byte) error {
 	}
 }
 
+type ExchangeTokenTooManyRequests struct {
+	Error            ExchangeTokenTooManyRequestsError `json:"error"`
+	ErrorDescription OptString                         `json:"error_description"`
+}
+
+// GetError returns the value of Error.
+func (s *ExchangeTokenTooManyRequests) GetError() ExchangeTokenTooManyRequestsError {
+	return s.Error
+}
+
+// GetErrorDescription returns the value of ErrorDescription.
+func (s *ExchangeTokenTooManyRequests) GetErrorDescription() OptString {
+	return s.ErrorDescription
+}
+
+// SetError sets the value of Error.
+func (s *ExchangeTokenTooManyRequests) SetError(val ExchangeTokenTooManyRequestsError) {
+	s.Error = val
+}
+
+// SetErrorDescription sets the value of ErrorDescription.
+func (s *ExchangeTokenTooManyRequests) SetErrorDescription(val OptString) {
+	s.ErrorDescription = val
+}
+
+type ExchangeTokenTooManyRequestsError string
+
+const (
+	ExchangeTokenTooManyRequestsErrorTemporarilyUnavailable ExchangeTokenTooManyRequestsError = "temporarily_unavailable"
+)
+
+// AllValues returns all ExchangeTokenTooManyRequestsError values.
+func (ExchangeTokenTooManyRequestsError) AllValues() []ExchangeTokenTooManyRequestsError {
+	return []ExchangeTokenTooManyRequestsError{
+		ExchangeTokenTooManyRequestsErrorTemporarilyUnavailable,
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (s ExchangeTokenTooManyRequestsError) MarshalText() ([]byte, error) {
+	switch s {
+	case ExchangeTokenTooManyRequestsErrorTemporarilyUnavailable:
+		return []byte(s), nil
+	default:
+		return nil, errors.Errorf("invalid value: %q", s)
+	}
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (s *ExchangeTokenTooManyRequestsError) UnmarshalText(data []byte) error {
+	switch ExchangeTokenTooManyRequestsError(data) {
+	case ExchangeTokenTooManyRequestsErrorTemporarilyUnavailable:
+		*s = ExchangeTokenTooManyRequestsErrorTemporarilyUnavailable
+		return nil
+	default:
+		return errors.Errorf("invalid value: %q", data)
+	}
+}
+
+// ExchangeTokenTooManyRequestsHeaders wraps ExchangeTokenTooManyRequests with response headers.
+type ExchangeTokenTooManyRequestsHeaders struct {
+	RetryAfter int
+	Response   ExchangeTokenTooManyRequests
+}
+
+// GetRetryAfter returns the value of RetryAfter.
+func (s *ExchangeTokenTooManyRequestsHeaders) GetRetryAfter() int {
+	return s.RetryAfter
+}
+
+// GetResponse returns the value of Response.
+func (s *ExchangeTokenTooManyRequestsHeaders) GetResponse() ExchangeTokenTooManyRequests {
+	return s.Response
+}
+
+// SetRetryAfter sets the value of RetryAfter.
+func (s *ExchangeTokenTooManyRequestsHeaders) SetRetryAfter(val int) {
+	s.RetryAfter = val
+}
+
+// SetResponse sets the value of Response.
+func (s *ExchangeTokenTooManyRequestsHeaders) SetResponse(val ExchangeTokenTooManyRequests) {
+	s.Response = val
+}
+
+func (*ExchangeTokenTooManyRequestsHeaders) exchangeTokenRes() {}
+
 // NewOptString returns new OptString with value set to v.
 func NewOptString(v string) OptString {
 	return OptString{
@@ -71,3 +71,14 @@ Create the name of the cluster role to use
 {{- default "default" .Values.clusterRole.name }}
 {{- end }}
 {{- end }}
+
+{{/*
+Format a map as comma separated key:value pairs
+*/}}
+{{- define "labid.keyValues" -}}
+{{- $pairs := list }}
+{{- range $k, $v := . }}
+{{- $pairs = append $pairs (printf "%s:%s" $k $v) }}
+{{- end }}
+{{- join "," $pairs }}
+{{- end }}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/caarlos0/env/v11"
+	"github.com/statisticsnorway/labid/internal/agent"
+	"github.com/statisticsnorway/labid/pkg/labid/client"
+)
+
+type config struct {
+	// Base URL of LabID, e.g. http://labid.labid.svc.cluster.local
+	Url       string   `env:"URL,required,notEmpty"`
+	Scopes    []string `env:"SCOPES" envDefault:"current_group"`
+	Audiences []string `env:"AUDIENCES"`
+	// Token to exchange, e.g. a projected service account token with LabID as
+	// its audience
+	ServiceAccountTokenFile string `env:"SERVICE_ACCOUNT_TOKEN_FILE" envDefault:"/var/run/secrets/kubernetes.io/serviceaccount/token"`
+
+	// File the LabID token is written to, usually on a volume shared with
+	// the other containers of the pod. Empty disables it.
+	TokenFile string `env:"TOKEN_FILE" envDefault:"/var/run/secrets/labid/token"`
+	// Address of the token endpoint, localhost only by default
+	Address string `env:"ADDRESS" envDefault:"127.0.0.1:8181"`
+
+	RefreshBefore time.Duration `env:"REFRESH_BEFORE" envDefault:"5m"`
+	MinBackoff    time.Duration `env:"MIN_BACKOFF" envDefault:"1s"`
+	MaxBackoff    time.Duration `env:"MAX_BACKOFF" envDefault:"1m"`
+
+	LogLevel slog.Level `env:"LOG_LEVEL" envDefault:"INFO"`
+}
+
+func main() {
+	cfg, err := env.ParseAsWithOptions[config](env.Options{
+		Prefix: "LABID_AGENT_",
+	})
+	if err != nil {
+		errorAndExit(fmt.Errorf("parse environment variables: %w", err))
+	}
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: cfg.LogLevel})))
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	tokens := client.NewTokenSource(cfg.Url,
+		client.WithScopes(cfg.Scopes...),
+		client.WithAudience(cfg.Audiences...),
+		client.WithServiceAccountTokenFile(cfg.ServiceAccountTokenFile),
+		client.WithRefreshBefore(cfg.RefreshBefore),
+	)
+	a := agent.New(tokens,
+		agent.WithTokenFile(cfg.TokenFile),
+		agent.WithRefreshBefore(cfg.RefreshBefore),
+		agent.WithBackoff(cfg.MinBackoff, cfg.MaxBackoff),
+	)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /token", a.ServeToken)
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("GET /readyz", a.Readyz)
+	server := &http.Server{
+		Addr:              cfg.Address,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		if err := a.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			slog.Error("run agent", "error", err.Error())
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	slog.Info("starting labid agent", "address", cfg.Address, "tokenFile", cfg.TokenFile)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		errorAndExit(fmt.Errorf("serve: %w", err))
+	}
+}
+
+func errorAndExit(err error) {
+	slog.Error(err.Error())
+	os.Exit(1)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Claims with NumericDate values, printed as times
+var timeClaims = []string{"iat", "nbf", "exp"}
+
+// decode prints the header and claims of a token without verifying it.
+func decode(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("decode", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	rawToken, err := readToken(fs.Args(), stdin)
+	if err != nil {
+		return err
+	}
+	header, claims, err := splitToken(rawToken)
+	if err != nil {
+		return err
+	}
+
+	for _, part := range []struct {
+		name string
+		data []byte
+	}{{"Header", header}, {"Claims", claims}} {
+		var out bytes.Buffer
+		if err := json.Indent(&out, part.data, "", "  "); err != nil {
+			return fmt.Errorf("%s is not JSON: %w", strings.ToLower(part.name), err)
+		}
+		fmt.Fprintf(stdout, "%s:\n%s\n\n", part.name, out.Bytes())
+	}
+
+	var times map[string]any
+	if err := json.Unmarshal(claims, &times); err != nil {
+		return fmt.Errorf("claims are not an object: %w", err)
+	}
+	fmt.Fprintln(stdout, "Times:")
+	now := time.Now()
+	for _, name := range timeClaims {
+		if seconds, ok := times[name].(float64); ok {
+			t := time.Unix(int64(seconds), 0)
+			fmt.Fprintf(stdout, "  %-4s %s (%s)\n", name, t.Local().Format(time.RFC3339), relative(t, now))
+		}
+	}
+	return nil
+}
+
+// splitToken returns the decoded header and claims of a compact JWS.
+func splitToken(rawToken string) (header, claims []byte, err error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return nil, nil, errors.New("token is not a JWT, expected three dot separated parts")
+	}
+	if header, err = base64.RawURLEncoding.DecodeString(parts[0]); err != nil {
+		return nil, nil, fmt.Errorf("decode header: %w", err)
+	}
+	if claims, err = base64.RawURLEncoding.DecodeString(parts[1]); err != nil {
+		return nil, nil, fmt.Errorf("decode claims: %w", err)
+	}
+	return header, claims, nil
+}
+
+// relative describes t relative to now, e.g. "in 55m0s" or "5m0s ago".
+func relative(t, now time.Time) string {
+	d := t.Sub(now).Round(time.Second)
+	if d < 0 {
+		return (-d).String() + " ago"
+	}
+	return "in " + d.String()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/statisticsnorway/labid/pkg/labid/client"
+	"golang.org/x/oauth2"
+)
+
+// exchange performs the RFC 8693 token exchange with a token file, and prints
+// the response, or only the token with -raw.
+func exchange(args []string, _ io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("exchange", flag.ContinueOnError)
+	url := fs.String("url", "", "base URL of LabID, e.g. http://labid.labid.svc.cluster.local")
+	tokenFile := fs.String("token-file", client.DefaultServiceAccountTokenFile, "file with the subject token")
+	scope := fs.String("scope", "", "comma separated scopes, e.g. current_group,all_groups")
+	var audiences []string
+	fs.Func("audience", "audience of the token, can be repeated", func(aud string) error {
+		audiences = append(audiences, aud)
+		return nil
+	})
+	raw := fs.Bool("raw", false, "print only the access token")
+	timeout := fs.Duration("timeout", 30*time.Second, "timeout of the exchange")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *url == "" {
+		return errors.New("-url is required")
+	}
+
+	opts := []client.Option{
+		client.WithServiceAccountTokenFile(*tokenFile),
+		client.WithAudience(audiences...),
+		client.WithHTTPClient(&http.Client{Timeout: *timeout}),
+	}
+	if *scope != "" {
+		opts = append(opts, client.WithScopes(strings.Split(*scope, ",")...))
+	}
+	token, err := client.NewTokenSource(*url, opts...).Token()
+	if retrieveErr := (*oauth2.RetrieveError)(nil); errors.As(err, &retrieveErr) {
+		if retrieveErr.ErrorCode != "" {
+			return fmt.Errorf("%s: %s: %s", retrieveErr.Response.Status, retrieveErr.ErrorCode, retrieveErr.ErrorDescription)
+		}
+		return fmt.Errorf("%s: %s", retrieveErr.Response.Status, retrieveErr.Body)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *raw {
+		_, err := fmt.Fprintln(stdout, token.AccessToken)
+		return err
+	}
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(map[string]any{
+		"access_token": token.AccessToken,
+		"token_type":   token.Type(),
+		"expires_in":   token.ExpiresIn,
+		"expiry":       token.Expiry.Format(time.RFC3339),
+	})
+}
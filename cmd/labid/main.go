@@ -0,0 +1,74 @@
+// Command labid exchanges, decodes and verifies LabID tokens, for debugging.
+//
+//	labid exchange -url http://labid.labid.svc.cluster.local -scope current_group
+//	labid decode <token>
+//	labid verify -jwks https://labid.lab.dapla.ssb.no/jwks -audience my-service <token>
+//
+// decode and verify read the token from stdin if it is not given.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const usage = `Usage: labid <command> [flags]
+
+Commands:
+  exchange  exchange a service account token for a LabID token
+  decode    print the header and claims of a token
+  verify    check the signature and claims of a token against a JWKS
+
+Run labid <command> -h for the flags of a command.
+`
+
+var commands = map[string]func(args []string, stdin io.Reader, stdout io.Writer) error{
+	"exchange": exchange,
+	"decode":   decode,
+	"verify":   verify,
+}
+
+// errFailed is returned by commands which printed why they failed, e.g. the
+// checks of verify.
+var errFailed = errors.New("failed")
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	command, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+	if err := command(os.Args[2:], os.Stdin, os.Stdout); err != nil {
+		if !errors.Is(err, errFailed) {
+			fmt.Fprintln(os.Stderr, "labid "+os.Args[1]+": "+err.Error())
+		}
+		os.Exit(1)
+	}
+}
+
+// readToken returns the token given as argument, or read from stdin.
+func readToken(args []string, stdin io.Reader) (string, error) {
+	switch len(args) {
+	case 0:
+		line, err := bufio.NewReader(stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", fmt.Errorf("read token from stdin: %w", err)
+		}
+		if token := strings.TrimSpace(line); token != "" {
+			return token, nil
+		}
+		return "", errors.New("no token given")
+	case 1:
+		return strings.TrimSpace(args[0]), nil
+	default:
+		return "", errors.New("expected one token")
+	}
+}
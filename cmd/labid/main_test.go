@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+)
+
+func signingKey(t *testing.T) jwk.Key {
+	t.Helper()
+	raw, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := jwk.Import(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk.AssignKeyID(key)
+	key.Set("alg", "RS256")
+	return key
+}
+
+// jwksFile writes the public key of key to a JWKS file.
+func jwksFile(t *testing.T, key jwk.Key) string {
+	t.Helper()
+	pub, err := key.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := jwk.NewSet()
+	set.AddKey(pub)
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func sign(t *testing.T, key jwk.Key, exp time.Time) string {
+	t.Helper()
+	token, err := jwt.NewBuilder().
+		Issuer("https://labid.lab.dapla.ssb.no").
+		Subject("kari").
+		Audience([]string{"storage"}).
+		IssuedAt(time.Now()).
+		Expiration(exp).
+		Claim("dapla.group", "dapla-felles-developers").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := jwt.Sign(token, jwt.WithKey(jwa.RS256(), key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(signed)
+}
+
+func TestDecode(t *testing.T) {
+	token := sign(t, signingKey(t), time.Now().Add(time.Hour))
+	var out bytes.Buffer
+	if err := decode(nil, strings.NewReader(token+"\n"), &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"alg": "RS256"`, `"dapla.group": "dapla-felles-developers"`, "iat ", "exp ", "(in "} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected %q in output:\n%s", want, out.String())
+		}
+	}
+	if err := decode([]string{"abc"}, nil, &out); err == nil {
+		t.Error("expected error for invalid token")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	key := signingKey(t)
+	jwks := jwksFile(t, key)
+	valid := sign(t, key, time.Now().Add(time.Hour))
+
+	for name, tc := range map[string]struct {
+		args   []string
+		failed []string
+	}{
+		"valid":     {[]string{"-jwks", jwks, "-issuer", "https://labid.lab.dapla.ssb.no", "-audience", "storage", valid}, nil},
+		"wrong key": {[]string{"-jwks", jwksFile(t, signingKey(t)), valid}, []string{"signature"}},
+		"wrong issuer and audience": {
+			[]string{"-jwks", jwks, "-issuer", "https://labid.example.com", "-audience", "vault", valid},
+			[]string{"iss", "aud"},
+		},
+		"expired": {[]string{"-jwks", jwks, sign(t, key, time.Now().Add(-time.Hour))}, []string{"exp"}},
+	} {
+		var out bytes.Buffer
+		err := verify(tc.args, nil, &out)
+		if len(tc.failed) == 0 && err != nil {
+			t.Errorf("%s: expected success, got %v:\n%s", name, err, out.String())
+		}
+		if len(tc.failed) > 0 && !errors.Is(err, errFailed) {
+			t.Errorf("%s: expected failed checks, got %v", name, err)
+		}
+		for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			check := strings.Fields(line)[0]
+			shouldFail := strings.Contains(strings.Join(tc.failed, " "), check)
+			if strings.Contains(line, "FAILED") != shouldFail {
+				t.Errorf("%s: unexpected result %q", name, line)
+			}
+		}
+	}
+}
+
+func TestVerifyFetchesJwks(t *testing.T) {
+	key := signingKey(t)
+	jwks, err := os.ReadFile(jwksFile(t, key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(jwks)
+	}))
+	defer srv.Close()
+
+	var out bytes.Buffer
+	if err := verify([]string{"-jwks", srv.URL}, strings.NewReader(sign(t, key, time.Now().Add(time.Hour))), &out); err != nil {
+		t.Fatalf("expected success, got %v:\n%s", err, out.String())
+	}
+}
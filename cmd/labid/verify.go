@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jws"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+)
+
+// check is a check of verify, which is skipped if run is nil.
+type check struct {
+	name string
+	run  func() error
+}
+
+// verify checks a token against a JWKS URL or file, and prints the result of
+// every check, so it is clear which one failed.
+func verify(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	jwks := fs.String("jwks", "", "URL or file of the JWKS, e.g. https://labid.lab.dapla.ssb.no/jwks")
+	issuer := fs.String("issuer", "", "expected issuer, not checked if empty")
+	audience := fs.String("audience", "", "expected audience, not checked if empty")
+	leeway := fs.Duration("leeway", 30*time.Second, "allowed clock skew for exp, nbf and iat")
+	timeout := fs.Duration("timeout", 30*time.Second, "timeout for fetching the JWKS")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *jwks == "" {
+		return errors.New("-jwks is required")
+	}
+	rawToken, err := readToken(fs.Args(), stdin)
+	if err != nil {
+		return err
+	}
+
+	keys, err := readJwks(*jwks, *timeout)
+	if err != nil {
+		return err
+	}
+	token, err := jwt.ParseInsecure([]byte(rawToken))
+	if err != nil {
+		return fmt.Errorf("parse token: %w", err)
+	}
+	now := time.Now()
+
+	checks := []check{
+		{"signature", func() error {
+			_, err := jws.Verify([]byte(rawToken), jws.WithKeySet(keys))
+			return err
+		}},
+		{"iss", nil},
+		{"aud", nil},
+		{"exp", func() error {
+			exp, ok := token.Expiration()
+			if !ok {
+				return errors.New("no exp claim")
+			}
+			if !now.Before(exp.Add(*leeway)) {
+				return fmt.Errorf("expired %s", relative(exp, now))
+			}
+			return nil
+		}},
+		{"nbf", func() error {
+			if nbf, ok := token.NotBefore(); ok && now.Add(*leeway).Before(nbf) {
+				return fmt.Errorf("not valid until %s", nbf.Local().Format(time.RFC3339))
+			}
+			return nil
+		}},
+		{"iat", func() error {
+			if iat, ok := token.IssuedAt(); ok && now.Add(*leeway).Before(iat) {
+				return fmt.Errorf("issued in the future, %s", relative(iat, now))
+			}
+			return nil
+		}},
+	}
+	if *issuer != "" {
+		checks[1].run = func() error {
+			if iss, _ := token.Issuer(); iss != *issuer {
+				return fmt.Errorf("issuer is %q, expected %q", iss, *issuer)
+			}
+			return nil
+		}
+	}
+	if *audience != "" {
+		checks[2].run = func() error {
+			if aud, _ := token.Audience(); !slices.Contains(aud, *audience) {
+				return fmt.Errorf("audience is %v, expected %q", aud, *audience)
+			}
+			return nil
+		}
+	}
+
+	failed := false
+	for _, c := range checks {
+		if c.run == nil {
+			fmt.Fprintf(stdout, "%-10s skipped\n", c.name)
+			continue
+		}
+		if err := c.run(); err != nil {
+			failed = true
+			fmt.Fprintf(stdout, "%-10s FAILED: %s\n", c.name, err)
+			continue
+		}
+		fmt.Fprintf(stdout, "%-10s ok\n", c.name)
+	}
+	if failed {
+		return errFailed
+	}
+	return nil
+}
+
+func readJwks(source string, timeout time.Duration) (jwk.Set, error) {
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		keys, err := jwk.Fetch(ctx, source)
+		if err != nil {
+			return nil, fmt.Errorf("fetch jwks: %w", err)
+		}
+		return keys, nil
+	}
+	keys, err := jwk.ReadFile(source)
+	if err != nil {
+		return nil, fmt.Errorf("read jwks file: %w", err)
+	}
+	return keys, nil
+}
@@ -9,23 +9,38 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
-	"strings"
+	"slices"
+	"sync/atomic"
 	"time"
 
-	"github.com/statisticsnorway/labid/internal/daplaapi"
+	"github.com/statisticsnorway/labid/internal/claims"
+	"github.com/statisticsnorway/labid/internal/extauthz"
+	"github.com/statisticsnorway/labid/internal/groups"
+	"github.com/statisticsnorway/labid/internal/identity"
+	"github.com/statisticsnorway/labid/internal/kubecache"
+	"github.com/statisticsnorway/labid/internal/vault"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"github.com/caarlos0/env/v11"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/httplog/v2"
 	"github.com/lestrrat-go/httprc/v3"
 	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/ogen-go/ogen/ogenerrors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	api "github.com/statisticsnorway/labid/api/oas"
-	"github.com/statisticsnorway/labid/internal/teamapi"
+	"github.com/statisticsnorway/labid/internal/ratelimit"
+	"github.com/statisticsnorway/labid/internal/tlsconfig"
 	"github.com/statisticsnorway/labid/internal/token"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/prometheus"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"google.golang.org/grpc"
 
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -37,24 +52,179 @@ type config struct {
 	Port           string `env:"PORT" envDefault:"8080"`
 	PrivateKeyFile string `env:"PRIVATE_KEY_FILE,required,notEmpty,unset"`
 
-	// dapla-api or team-api
+	// Namespaces matching the user pattern are mapped to users, and those
+	// matching the service pattern to services. The templates are executed
+	// with the named capture groups of the pattern, namespace and
+	// serviceAccount.
+	UserNamespacePattern    string `env:"USER_NAMESPACE_PATTERN" envDefault:"^user-ssb-(?P<user>.+)$"`
+	UsernameTemplate        string `env:"USERNAME_TEMPLATE" envDefault:"{{.user}}"`
+	UserEmailTemplate       string `env:"USER_EMAIL_TEMPLATE" envDefault:"{{.user}}@ssb.no"`
+	ServiceNamespacePattern string `env:"SERVICE_NAMESPACE_PATTERN"`
+	ServiceSubjectTemplate  string `env:"SERVICE_SUBJECT_TEMPLATE" envDefault:"system:serviceaccount:{{.namespace}}:{{.serviceAccount}}"`
+
+	// Read the owner of namespaces from this annotation or label, e.g.
+	// onyxia.sh/owner, falling back to the patterns above for namespaces
+	// without it. The templates are executed with owner, namespace and
+	// serviceAccount. Requires get, list and watch on namespaces.
+	NamespaceOwnerKey           string        `env:"NAMESPACE_OWNER_KEY"`
+	NamespaceOwnerUserTemplate  string        `env:"NAMESPACE_OWNER_USERNAME_TEMPLATE" envDefault:"{{.owner}}"`
+	NamespaceOwnerEmailTemplate string        `env:"NAMESPACE_OWNER_EMAIL_TEMPLATE" envDefault:"{{.owner}}@ssb.no"`
+	NamespaceCacheResync        time.Duration `env:"NAMESPACE_CACHE_RESYNC" envDefault:"10m"`
+
+	// Claims set by CEL expressions, see internal/claims. The mapping is
+	// compiled and its tests run at startup.
+	ClaimMappingFile string `env:"CLAIM_MAPPING_FILE"`
+
+	// Service account annotations starting with these prefixes become claims,
+	// e.g. labid.dapla.ssb.no/claim-project. Claims are strings unless typed
+	// as int, float, bool or list, e.g. level:int,datasets:list.
+	AnnotationClaimPrefixes []string          `env:"ANNOTATION_CLAIM_PREFIXES"`
+	AnnotationClaimTypes    map[string]string `env:"ANNOTATION_CLAIM_TYPES"`
+
+	// dapla-api or team-api, superseded by GroupProviders
 	ApiImplementation string `env:"API_IMPLEMENTATION"`
 
+	// Ordered group providers, see groupProviderFactories, and how they are
+	// combined (fallback, union or shadow)
+	GroupProviders    []string `env:"GROUP_PROVIDERS"`
+	GroupProviderMode string   `env:"GROUP_PROVIDER_MODE" envDefault:"fallback"`
+
 	TeamApiUrl          string `env:"TEAM_API_URL"`
 	TeamApiClientId     string `env:"TEAM_API_CLIENT_ID"`
 	TeamApiClientSecret string `env:"TEAM_API_CLIENT_SECRET"`
 	TeamApiTokenUrl     string `env:"TEAM_API_TOKEN_URL"`
+	// Attempts per lookup, and consecutive failed lookups before requests to
+	// the Team API are suspended for the cooldown
+	TeamApiAttempts        int           `env:"TEAM_API_ATTEMPTS" envDefault:"3"`
+	TeamApiBreakerFailures int           `env:"TEAM_API_BREAKER_FAILURES" envDefault:"5"`
+	TeamApiBreakerCooldown time.Duration `env:"TEAM_API_BREAKER_COOLDOWN" envDefault:"30s"`
 
 	DaplaApiUrl   string `env:"DAPLA_API_URL"`
 	DaplaApiToken string `env:"DAPLA_API_TOKEN"`
+	// Exchanges for users with more groups than the max fail
+	DaplaApiPageSize  int `env:"DAPLA_API_PAGE_SIZE" envDefault:"100"`
+	DaplaApiMaxGroups int `env:"DAPLA_API_MAX_GROUPS" envDefault:"5000"`
+
+	// Users are found under the user base DN by their user principal name. If
+	// a group base DN is set, nested groups are resolved by searching it,
+	// otherwise memberOf is used. The first capture group of the group
+	// pattern applied to the group DNs is used as group name. With StartTLS
+	// the certificate is verified against the TLS server name, by default the
+	// host of the URL.
+	LdapUrl           string `env:"LDAP_URL"`
+	LdapBindDn        string `env:"LDAP_BIND_DN"`
+	LdapBindPassword  string `env:"LDAP_BIND_PASSWORD,unset"`
+	LdapUserBaseDn    string `env:"LDAP_USER_BASE_DN"`
+	LdapUserFilter    string `env:"LDAP_USER_FILTER" envDefault:"(userPrincipalName=%s)"`
+	LdapGroupBaseDn   string `env:"LDAP_GROUP_BASE_DN"`
+	LdapGroupPattern  string `env:"LDAP_GROUP_PATTERN"`
+	LdapStartTls      bool   `env:"LDAP_START_TLS"`
+	LdapTlsServerName string `env:"LDAP_TLS_SERVER_NAME"`
+
+	// Groups are read from the groups attribute of SCIM users, or by searching
+	// /Groups for the user if group search is enabled
+	ScimUrl          string   `env:"SCIM_URL"`
+	ScimTokenUrl     string   `env:"SCIM_TOKEN_URL"`
+	ScimClientId     string   `env:"SCIM_CLIENT_ID"`
+	ScimClientSecret string   `env:"SCIM_CLIENT_SECRET,unset"`
+	ScimScopes       []string `env:"SCIM_SCOPES"`
+	ScimGroupSearch  bool     `env:"SCIM_GROUP_SEARCH"`
+	ScimPageSize     int      `env:"SCIM_PAGE_SIZE" envDefault:"100"`
+	// Attempts per lookup, and consecutive failed lookups before requests to
+	// the SCIM service are suspended for the cooldown
+	ScimAttempts        int           `env:"SCIM_ATTEMPTS" envDefault:"3"`
+	ScimBreakerFailures int           `env:"SCIM_BREAKER_FAILURES" envDefault:"5"`
+	ScimBreakerCooldown time.Duration `env:"SCIM_BREAKER_COOLDOWN" envDefault:"30s"`
+
+	// Mapping of users to groups for the static provider, from a file or from
+	// a ConfigMap given as <namespace>/<name>
+	StaticGroupsFile           string        `env:"STATIC_GROUPS_FILE"`
+	StaticGroupsReloadInterval time.Duration `env:"STATIC_GROUPS_RELOAD_INTERVAL" envDefault:"30s"`
+	StaticGroupsConfigMap      string        `env:"STATIC_GROUPS_CONFIGMAP"`
+	StaticGroupsConfigMapKey   string        `env:"STATIC_GROUPS_CONFIGMAP_KEY" envDefault:"groups.yaml"`
+
+	// Per audience filters of dapla.groups, see groups.Filters. Above the
+	// overage threshold (0 disables it), the groups are left out of tokens
+	// and looked up at the overage endpoint instead, by default /groups.
+	// Tokens with all_groups get the email claim the groups endpoints look
+	// users up by, unless disabled, in which case only tokens on overage get
+	// it.
+	GroupFiltersFile       string `env:"GROUP_FILTERS_FILE"`
+	GroupsOverageThreshold int    `env:"GROUPS_OVERAGE_THRESHOLD"`
+	GroupsOverageEndpoint  string `env:"GROUPS_OVERAGE_ENDPOINT"`
+	GroupsEmailClaim       bool   `env:"GROUPS_EMAIL_CLAIM" envDefault:"true"`
+
+	// Team roles and how groups are named, see groups.Hierarchy. Adds the
+	// dapla.teams and dapla.team_roles claims with all_groups.
+	TeamHierarchyFile string `env:"TEAM_HIERARCHY_FILE"`
+
+	// Issue Google Cloud Workload Identity Federation compatible tokens for
+	// iam.googleapis.com audiences, optionally only for the listed providers
+	WifEnabled          bool     `env:"WIF_ENABLED"`
+	WifAllowedProviders []string `env:"WIF_ALLOWED_PROVIDERS"`
+
+	// Flat claims for the Vault JWT auth method on tokens for the Vault
+	// audiences, see vault.Profile
+	VaultAudiences    []string `env:"VAULT_AUDIENCES"`
+	VaultRoleTemplate string   `env:"VAULT_ROLE_TEMPLATE"`
+
+	// Envoy ext_authz service verifying LabID tokens, over HTTP on
+	// /ext_authz and over gRPC on its own port if set, see extauthz.Authorizer
+	ExtAuthzEnabled       bool              `env:"EXT_AUTHZ_ENABLED"`
+	ExtAuthzGrpcPort      string            `env:"EXT_AUTHZ_GRPC_PORT"`
+	ExtAuthzAudiences     []string          `env:"EXT_AUTHZ_AUDIENCES"`
+	ExtAuthzRequiredGroup string            `env:"EXT_AUTHZ_REQUIRED_GROUP"`
+	ExtAuthzClaimHeaders  map[string]string `env:"EXT_AUTHZ_CLAIM_HEADERS"`
+
+	// How long group lookups are cached, how long unknown users are cached,
+	// and for how long after expiry cached groups are served while refreshing
+	GroupsCacheTtl         time.Duration `env:"GROUPS_CACHE_TTL" envDefault:"5m"`
+	GroupsCacheNegativeTtl time.Duration `env:"GROUPS_CACHE_NEGATIVE_TTL" envDefault:"1m"`
+	GroupsCacheStaleTtl    time.Duration `env:"GROUPS_CACHE_STALE_TTL" envDefault:"1h"`
+
+	// Watch service accounts instead of fetching them on every exchange.
+	// Requires list and watch on service accounts.
+	SaCacheEnabled         bool          `env:"SA_CACHE_ENABLED" envDefault:"true"`
+	SaCacheLabelSelector   string        `env:"SA_CACHE_LABEL_SELECTOR"`
+	SaCacheNamespacePrefix string        `env:"SA_CACHE_NAMESPACE_PREFIX" envDefault:"user-ssb-"`
+	SaCacheResync          time.Duration `env:"SA_CACHE_RESYNC" envDefault:"10m"`
 
 	Host string `env:"HOST,required,notEmpty"`
 
 	LogLevel slog.Level `env:"LOG_LEVEL" envDefault:"INFO"`
+
+	ReadHeaderTimeout time.Duration `env:"READ_HEADER_TIMEOUT" envDefault:"5s"`
+	ReadTimeout       time.Duration `env:"READ_TIMEOUT" envDefault:"10s"`
+	WriteTimeout      time.Duration `env:"WRITE_TIMEOUT" envDefault:"30s"`
+	IdleTimeout       time.Duration `env:"IDLE_TIMEOUT" envDefault:"120s"`
+	MaxHeaderBytes    int           `env:"MAX_HEADER_BYTES" envDefault:"65536"`
+
+	// Time between failing readiness and closing listeners on SIGTERM
+	ShutdownDrainPeriod time.Duration `env:"SHUTDOWN_DRAIN_PERIOD" envDefault:"5s"`
+	ShutdownTimeout     time.Duration `env:"SHUTDOWN_TIMEOUT" envDefault:"20s"`
+
+	// TLS is served directly when cert and key files are set, setting only
+	// one of them is an error
+	TlsCertFile          string        `env:"TLS_CERT_FILE"`
+	TlsKeyFile           string        `env:"TLS_KEY_FILE"`
+	TlsClientCaFile      string        `env:"TLS_CLIENT_CA_FILE"`
+	TlsRequireClientCert bool          `env:"TLS_REQUIRE_CLIENT_CERT" envDefault:"true"`
+	TlsMinVersion        string        `env:"TLS_MIN_VERSION" envDefault:"1.2"`
+	TlsReloadInterval    time.Duration `env:"TLS_RELOAD_INTERVAL" envDefault:"1m"`
+
+	// Token buckets for /token, a rate of 0 disables the limiter. The client
+	// IP limit applies before the subject token is validated, the subject
+	// limit after, keyed by namespace and service account.
+	RateLimitIpRate       float64 `env:"RATE_LIMIT_IP_RATE" envDefault:"0"`
+	RateLimitIpBurst      int     `env:"RATE_LIMIT_IP_BURST" envDefault:"50"`
+	RateLimitIpHeader     string  `env:"RATE_LIMIT_IP_HEADER"`
+	RateLimitSubjectRate  float64 `env:"RATE_LIMIT_SUBJECT_RATE" envDefault:"0"`
+	RateLimitSubjectBurst int     `env:"RATE_LIMIT_SUBJECT_BURST" envDefault:"20"`
 }
 
 func main() {
-	ctx := context.Background()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	log := slog.New(slog.NewJSONHandler(os.Stdout, nil))
 
 	cfg, err := env.ParseAsWithOptions[config](env.Options{
@@ -93,6 +263,19 @@ func main() {
 	getSa := func(ctx context.Context, name, namespace string) (*corev1.ServiceAccount, error) {
 		return clientset.CoreV1().ServiceAccounts(namespace).Get(ctx, name, metav1.GetOptions{})
 	}
+	if cfg.SaCacheEnabled {
+		serviceAccounts, err := kubecache.NewServiceAccounts(
+			ctx,
+			clientset,
+			kubecache.WithLabelSelector(cfg.SaCacheLabelSelector),
+			kubecache.WithNamespacePrefix(cfg.SaCacheNamespacePrefix),
+			kubecache.WithResync(cfg.SaCacheResync),
+		)
+		if err != nil {
+			errorAndExit(fmt.Errorf("create service account cache: %w", err))
+		}
+		getSa = serviceAccounts.Get
+	}
 
 	kubernetesTokenParser := token.NewKubernetesTokenParser(jwksGetter)
 
@@ -104,31 +287,116 @@ func main() {
 		errorAndExit(fmt.Errorf("create signed jwt issuer: %w", err))
 	}
 
+	getNamespace := func(ctx context.Context, name string) (*corev1.Namespace, error) {
+		return clientset.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+	}
+	if cfg.NamespaceOwnerKey != "" || cfg.ClaimMappingFile != "" {
+		namespaces, err := kubecache.NewNamespaces(ctx, clientset, cfg.NamespaceCacheResync)
+		if err != nil {
+			errorAndExit(fmt.Errorf("create namespace cache: %w", err))
+		}
+		getNamespace = namespaces.Get
+	}
+
+	identities, err := newIdentityResolver(cfg, getNamespace)
+	if err != nil {
+		errorAndExit(fmt.Errorf("create identity resolver: %w", err))
+	}
+
 	thOpts := []token.ThOptsFunc{
 		token.WithCurrentGroupPopulator(token.CurrentGroupMapper(ctx, getSa)),
+		token.WithIdentityResolver(identities.Resolve),
 	}
-	if strings.EqualFold(cfg.ApiImplementation, "team-api") {
-		thOpts = append(
-			thOpts,
-			token.WithAllGroupsPopulator(
-				teamapi.NewClient(
-					cfg.TeamApiUrl,
-					cfg.TeamApiTokenUrl,
-					cfg.TeamApiClientId,
-					cfg.TeamApiClientSecret,
-				).AllGroupsPopulator,
-			),
-		)
-	} else if strings.EqualFold(cfg.ApiImplementation, "dapla-api") {
-		thOpts = append(
-			thOpts,
-			token.WithAllGroupsPopulator(
-				daplaapi.NewClient(
-					cfg.DaplaApiUrl,
-					cfg.DaplaApiToken,
-				).AllGroupsPopulator,
-			),
-		)
+	groupProvider, err := newGroupProvider(ctx, cfg, clientset)
+	if err != nil {
+		errorAndExit(fmt.Errorf("create group provider: %w", err))
+	}
+	var filters *groups.Filters
+	var hierarchy *groups.Hierarchy
+	if groupProvider != nil {
+		if cfg.GroupFiltersFile != "" {
+			data, err := os.ReadFile(cfg.GroupFiltersFile)
+			if err != nil {
+				errorAndExit(fmt.Errorf("read group filters file: %w", err))
+			}
+			if filters, err = groups.ParseFilters(data); err != nil {
+				errorAndExit(fmt.Errorf("parse group filters: %w", err))
+			}
+		}
+		if cfg.TeamHierarchyFile != "" {
+			data, err := os.ReadFile(cfg.TeamHierarchyFile)
+			if err != nil {
+				errorAndExit(fmt.Errorf("read team hierarchy file: %w", err))
+			}
+			if hierarchy, err = groups.ParseHierarchy(data); err != nil {
+				errorAndExit(fmt.Errorf("parse team hierarchy: %w", err))
+			}
+		}
+		overageEndpoint := cfg.GroupsOverageEndpoint
+		if overageEndpoint == "" {
+			overageEndpoint = cfg.Host + "/groups"
+		}
+		thOpts = append(thOpts, token.WithAllGroupsPopulator(groups.AllGroupsPopulator(
+			groupProvider,
+			groups.WithFilters(filters),
+			groups.WithOverage(cfg.GroupsOverageThreshold, overageEndpoint),
+			groups.WithHierarchy(hierarchy),
+			groups.WithEmailClaim(cfg.GroupsEmailClaim),
+		)))
+	}
+	// Claims set by LabID beyond token.ReservedClaims, which mappings cannot set
+	var mappedClaims []string
+	if hierarchy != nil {
+		mappedClaims = append(mappedClaims, groups.TeamsClaim, groups.TeamRolesClaim)
+	}
+	if len(cfg.VaultAudiences) > 0 {
+		profile, err := vault.NewProfile(cfg.VaultAudiences, cfg.VaultRoleTemplate)
+		if err != nil {
+			errorAndExit(fmt.Errorf("create vault profile: %w", err))
+		}
+		thOpts = append(thOpts, token.WithClaimsPopulator(vault.Populator(profile, vault.Sources{
+			GetServiceAccount: getSa,
+			Groups:            groupProvider,
+			Filters:           filters,
+			OverageThreshold:  cfg.GroupsOverageThreshold,
+		})))
+		mappedClaims = append(mappedClaims, vault.Claims...)
+	}
+	if cfg.ClaimMappingFile != "" {
+		data, err := os.ReadFile(cfg.ClaimMappingFile)
+		if err != nil {
+			errorAndExit(fmt.Errorf("read claim mapping file: %w", err))
+		}
+		policy, err := claims.Parse(data)
+		if err != nil {
+			errorAndExit(fmt.Errorf("compile claim mapping: %w", err))
+		}
+		for _, name := range policy.Names() {
+			if slices.Contains(mappedClaims, name) {
+				errorAndExit(fmt.Errorf("claim mapping sets %q, which is already set by LabID", name))
+			}
+		}
+		thOpts = append(thOpts, token.WithClaimsPopulator(claims.Populator(policy, claims.Sources{
+			GetServiceAccount: getSa,
+			GetNamespace:      getNamespace,
+			Groups:            groupProvider,
+		})))
+		mappedClaims = append(mappedClaims, policy.Names()...)
+	}
+	if len(cfg.AnnotationClaimPrefixes) > 0 {
+		annotations, err := claims.NewAnnotations(cfg.AnnotationClaimPrefixes, cfg.AnnotationClaimTypes, mappedClaims...)
+		if err != nil {
+			errorAndExit(fmt.Errorf("create annotation claims: %w", err))
+		}
+		thOpts = append(thOpts, token.WithClaimsPopulator(claims.AnnotationsPopulator(annotations, getSa)))
+	}
+	if cfg.WifEnabled {
+		thOpts = append(thOpts, token.WithWorkloadIdentityFederation(cfg.WifAllowedProviders))
+	}
+	if cfg.RateLimitSubjectRate > 0 {
+		thOpts = append(thOpts, token.WithRateLimiter(
+			ratelimit.New("subject", cfg.RateLimitSubjectRate, cfg.RateLimitSubjectBurst),
+		))
 	}
 	tokenHandler, err := token.NewTokenHandler(
 		kubernetesTokenParser.Parse, signedJwtCreator,
@@ -138,7 +406,15 @@ func main() {
 		errorAndExit(fmt.Errorf("create token handler: %w", err))
 	}
 
-	srv, err := api.NewServer(tokenHandler)
+	metricsHandler, err := initializeMetrics()
+	if err != nil {
+		errorAndExit(fmt.Errorf("initialize metrics: %w", err))
+	}
+
+	srv, err := api.NewServer(
+		tokenHandler,
+		api.WithErrorHandler(ratelimit.ErrorHandler(ogenerrors.DefaultErrorHandler)),
+	)
 	if err != nil {
 		errorAndExit(fmt.Errorf("create api server: %w", err))
 	}
@@ -150,21 +426,97 @@ func main() {
 		QuietDownPeriod: 10 * time.Second,
 	})
 
+	var ready atomic.Bool
+
 	r := chi.NewRouter()
-	r.Use(httplog.RequestLogger(middlelog))
-	r.Mount("/", srv)
+	r.Get("/healthz", Healthz)
+	r.Get("/readyz", Readyz(&ready))
+	r.Handle("/metrics", metricsHandler)
+	var grpcServer *grpc.Server
+	if cfg.ExtAuthzEnabled {
+		authorizer := extauthz.NewAuthorizer(signedJwtCreator.Verify,
+			extauthz.WithAudiences(cfg.ExtAuthzAudiences),
+			extauthz.WithRequiredGroup(cfg.ExtAuthzRequiredGroup),
+			extauthz.WithClaimHeaders(cfg.ExtAuthzClaimHeaders),
+		)
+		// Not logged, since every request to the protected services is checked
+		r.Handle("/ext_authz/*", authorizer.HTTPHandler("/ext_authz"))
+
+		if cfg.ExtAuthzGrpcPort != "" {
+			lis, err := net.Listen("tcp", fmt.Sprintf(":%s", cfg.ExtAuthzGrpcPort))
+			if err != nil {
+				errorAndExit(fmt.Errorf("listen for ext_authz grpc: %w", err))
+			}
+			grpcServer = grpc.NewServer()
+			authv3.RegisterAuthorizationServer(grpcServer, extauthz.NewGrpcServer(authorizer))
+			go func() {
+				if err := grpcServer.Serve(lis); err != nil {
+					log.Error("serve ext_authz grpc", "error", err.Error())
+				}
+			}()
+		}
+	}
 	r.Group(func(r chi.Router) {
+		r.Use(httplog.RequestLogger(middlelog))
+
+		limited := r
+		if cfg.RateLimitIpRate > 0 {
+			ipLimiter := ratelimit.New("client_ip", cfg.RateLimitIpRate, cfg.RateLimitIpBurst)
+			limited = r.With(ipLimiter.Middleware(ratelimit.ClientIP(cfg.RateLimitIpHeader)))
+		}
+		limited.Mount("/", srv)
+		if groupProvider != nil {
+			groupsApi := groups.NewAPI(groupProvider, signedJwtCreator.Verify, filters)
+			limited.Get("/groups", groupsApi.List)
+			limited.Get("/groups/check", groupsApi.Check)
+		}
+
 		jwks, err := Jwks(localJwks)
 		if err != nil {
 			errorAndExit(fmt.Errorf("create jwks handler: %w", err))
 		}
 		r.Get("/jwks", jwks)
-		r.Get("/.well-known/openid-configuration", WellKnown(cfg.Host))
+		r.Get("/.well-known/openid-configuration", WellKnown(cfg.Host, mappedClaims...))
 	})
 
-	if err := http.ListenAndServe(fmt.Sprintf(":%s", cfg.Port), r); err != nil {
+	server := &http.Server{
+		Addr:              fmt.Sprintf(":%s", cfg.Port),
+		Handler:           r,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+
+	if (cfg.TlsCertFile == "") != (cfg.TlsKeyFile == "") {
+		errorAndExit(errors.New("both LABID_TLS_CERT_FILE and LABID_TLS_KEY_FILE must be set to serve TLS"))
+	}
+	if cfg.TlsCertFile != "" {
+		minVersion, err := tlsconfig.ParseVersion(cfg.TlsMinVersion)
+		if err != nil {
+			errorAndExit(fmt.Errorf("parse tls min version: %w", err))
+		}
+		reloader, err := tlsconfig.NewReloader(cfg.TlsCertFile, cfg.TlsKeyFile, cfg.TlsClientCaFile)
+		if err != nil {
+			errorAndExit(fmt.Errorf("load tls certificates: %w", err))
+		}
+		go reloader.Watch(ctx, cfg.TlsReloadInterval)
+		server.TLSConfig = reloader.Config(minVersion)
+		if cfg.TlsClientCaFile != "" && cfg.TlsRequireClientCert {
+			// The kubelet has no client certificate for the probes
+			server.Handler = tlsconfig.RequireClientCert("/healthz", "/readyz", "/metrics")(server.Handler)
+		}
+	}
+
+	if err := serve(server, &ready, cfg.ShutdownDrainPeriod, cfg.ShutdownTimeout); err != nil {
 		log.Error(err.Error())
 	}
+	if grpcServer != nil {
+		grpcServer.GracefulStop()
+	}
+	// Stops the background refresh of the external JWKS cache
+	cancel()
 }
 
 func ParseRsaKeyPair(rawPrivateKey []byte) (private jwk.Key, public jwk.Key, err error) {
@@ -216,6 +568,51 @@ func CachedJwksGetter(ctx context.Context, jwksUri string) (token.JwksGetter, er
 	return token.JwksGetterFunc(getJwks), nil
 }
 
+// newIdentityResolver builds the namespace to identity rules from the user and
+// service patterns and templates, resolving users from the owner of
+// namespaces first if an owner key is set.
+func newIdentityResolver(cfg config, getNamespace identity.NamespaceGetter) (identity.Resolver, error) {
+	var mapping identity.Mapping
+	user, err := identity.UserRule(cfg.UserNamespacePattern, cfg.UsernameTemplate, cfg.UserEmailTemplate)
+	if err != nil {
+		return nil, err
+	}
+	mapping = append(mapping, user)
+	if cfg.ServiceNamespacePattern != "" {
+		service, err := identity.ServiceRule(cfg.ServiceNamespacePattern, cfg.ServiceSubjectTemplate)
+		if err != nil {
+			return nil, err
+		}
+		mapping = append(mapping, service)
+	}
+	if cfg.NamespaceOwnerKey == "" {
+		return mapping, nil
+	}
+
+	owner, err := identity.NewNamespaceOwner(
+		getNamespace,
+		cfg.NamespaceOwnerKey,
+		cfg.NamespaceOwnerUserTemplate,
+		cfg.NamespaceOwnerEmailTemplate,
+		mapping,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return owner, nil
+}
+
+// initializeMetrics registers a global Prometheus backed meter provider and
+// returns the handler exposing its metrics.
+func initializeMetrics() (http.Handler, error) {
+	exporter, err := prometheus.New()
+	if err != nil {
+		return nil, fmt.Errorf("create prometheus exporter: %w", err)
+	}
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(exporter)))
+	return promhttp.Handler(), nil
+}
+
 func errorAndExit(err error) {
 	slog.Error(err.Error())
 	os.Exit(1)
@@ -237,13 +634,18 @@ func initializeKubernetesClient() (*kubernetes.Clientset, error) {
 	return kubernetes.NewForConfig(config)
 }
 
-func WellKnown(host string) func(http.ResponseWriter, *http.Request) {
+func WellKnown(host string, extraClaims ...string) func(http.ResponseWriter, *http.Request) {
 	wellknown := map[string]any{
 		"issuer":           host,
 		"jwks_uri":         fmt.Sprintf("%s/jwks", host),
 		"token_endpoint":   fmt.Sprintf("%s/token", host),
 		"scopes_supported": []string{"current_group", "all_groups"},
-		"claims_supported": []string{"iss", "sub", "dapla.group", "dapla.groups"},
+		"claims_supported": append([]string{"iss", "sub", "dapla.group", "dapla.groups"}, extraClaims...),
+		// Required by OpenID Connect discovery, and checked by Google Cloud
+		// STS for workload identity federation
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"response_types_supported":              []string{"id_token"},
+		"subject_types_supported":               []string{"public"},
 	}
 	b, _ := json.Marshal(wellknown)
 	return func(w http.ResponseWriter, r *http.Request) {
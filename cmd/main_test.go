@@ -1,7 +1,10 @@
 package main
 
 import (
+	"encoding/json"
+	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
 
 	"github.com/lestrrat-go/jwx/v3/jwk"
@@ -22,6 +25,28 @@ func TestWellKnown(t *testing.T) {
 	}
 }
 
+func TestWellKnownWifMetadata(t *testing.T) {
+	w := httptest.NewRecorder()
+	WellKnown("https://labid.lab.dapla.ssb.no")(w, httptest.NewRequest("GET", "/", nil))
+
+	var metadata map[string]any
+	if err := json.NewDecoder(w.Body).Decode(&metadata); err != nil {
+		t.Fatal(err)
+	}
+	// The fields Google Cloud STS requires from an OIDC provider
+	for _, field := range []string{
+		"issuer", "jwks_uri", "id_token_signing_alg_values_supported",
+		"response_types_supported", "subject_types_supported",
+	} {
+		if _, ok := metadata[field]; !ok {
+			t.Errorf("expected %q in discovery metadata", field)
+		}
+	}
+	if metadata["jwks_uri"] != "https://labid.lab.dapla.ssb.no/jwks" {
+		t.Errorf("unexpected jwks_uri %v", metadata["jwks_uri"])
+	}
+}
+
 func TestJwks(t *testing.T) {
 	wk, _ := Jwks(jwk.NewSet())
 
@@ -35,3 +60,23 @@ func TestJwks(t *testing.T) {
 		t.Errorf("expected content-type application/json, got %q", contentType)
 	}
 }
+
+func TestReadyz(t *testing.T) {
+	var ready atomic.Bool
+	rz := Readyz(&ready)
+
+	for _, tc := range []struct {
+		ready bool
+		code  int
+	}{
+		{false, http.StatusServiceUnavailable},
+		{true, http.StatusOK},
+	} {
+		ready.Store(tc.ready)
+		w := httptest.NewRecorder()
+		rz(w, httptest.NewRequest("GET", "/readyz", nil))
+		if w.Code != tc.code {
+			t.Errorf("ready=%t: expected status %d, got %d", tc.ready, tc.code, w.Code)
+		}
+	}
+}
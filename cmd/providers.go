@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/statisticsnorway/labid/internal/daplaapi"
+	"github.com/statisticsnorway/labid/internal/groups"
+	"github.com/statisticsnorway/labid/internal/ldapgroups"
+	"github.com/statisticsnorway/labid/internal/scim"
+	"github.com/statisticsnorway/labid/internal/staticgroups"
+	"github.com/statisticsnorway/labid/internal/teamapi"
+	"github.com/statisticsnorway/labid/internal/upstream"
+	"k8s.io/client-go/kubernetes"
+)
+
+// groupProviderFactories returns the group providers which can be listed in
+// LABID_GROUP_PROVIDERS, by name.
+func groupProviderFactories(ctx context.Context, cfg config, clientset kubernetes.Interface) map[string]func() (groups.Provider, error) {
+	return map[string]func() (groups.Provider, error){
+		"team-api": func() (groups.Provider, error) {
+			return teamapi.NewClient(
+				cfg.TeamApiUrl,
+				cfg.TeamApiTokenUrl,
+				cfg.TeamApiClientId,
+				cfg.TeamApiClientSecret,
+				teamapi.WithBackoff(upstream.Backoff{
+					Attempts:  cfg.TeamApiAttempts,
+					BaseDelay: 100 * time.Millisecond,
+					MaxDelay:  2 * time.Second,
+				}),
+				teamapi.WithBreaker(upstream.NewBreaker(
+					"team-api",
+					cfg.TeamApiBreakerFailures,
+					cfg.TeamApiBreakerCooldown,
+				)),
+			), nil
+		},
+		"dapla-api": func() (groups.Provider, error) {
+			return daplaapi.NewClient(
+				cfg.DaplaApiUrl,
+				cfg.DaplaApiToken,
+				daplaapi.WithPageSize(cfg.DaplaApiPageSize),
+				daplaapi.WithMaxGroups(cfg.DaplaApiMaxGroups),
+			), nil
+		},
+		"ldap": func() (groups.Provider, error) {
+			var pattern *regexp.Regexp
+			if cfg.LdapGroupPattern != "" {
+				var err error
+				if pattern, err = regexp.Compile(cfg.LdapGroupPattern); err != nil {
+					return nil, fmt.Errorf("compile ldap group pattern: %w", err)
+				}
+			}
+			var tlsConfig *tls.Config
+			if cfg.LdapStartTls {
+				serverName := cfg.LdapTlsServerName
+				if serverName == "" {
+					u, err := url.Parse(cfg.LdapUrl)
+					if err != nil {
+						return nil, fmt.Errorf("parse ldap url: %w", err)
+					}
+					serverName = u.Hostname()
+				}
+				tlsConfig = &tls.Config{
+					ServerName: serverName,
+					MinVersion: tls.VersionTLS12,
+				}
+			}
+			return ldapgroups.NewClient(
+				cfg.LdapUrl,
+				cfg.LdapBindDn,
+				cfg.LdapBindPassword,
+				cfg.LdapUserBaseDn,
+				ldapgroups.WithUserFilter(cfg.LdapUserFilter),
+				ldapgroups.WithNestedGroups(cfg.LdapGroupBaseDn),
+				ldapgroups.WithGroupPattern(pattern),
+				ldapgroups.WithStartTLS(tlsConfig),
+			), nil
+		},
+		"scim": func() (groups.Provider, error) {
+			return scim.NewClient(
+				cfg.ScimUrl,
+				cfg.ScimTokenUrl,
+				cfg.ScimClientId,
+				cfg.ScimClientSecret,
+				cfg.ScimScopes,
+				scim.WithGroupSearch(cfg.ScimGroupSearch),
+				scim.WithPageSize(cfg.ScimPageSize),
+				scim.WithBackoff(upstream.Backoff{
+					Attempts:  cfg.ScimAttempts,
+					BaseDelay: 100 * time.Millisecond,
+					MaxDelay:  2 * time.Second,
+				}),
+				scim.WithBreaker(upstream.NewBreaker(
+					"scim",
+					cfg.ScimBreakerFailures,
+					cfg.ScimBreakerCooldown,
+				)),
+			), nil
+		},
+		"static": func() (groups.Provider, error) {
+			if cfg.StaticGroupsFile != "" {
+				return staticgroups.NewFromFile(ctx, cfg.StaticGroupsFile, cfg.StaticGroupsReloadInterval)
+			}
+			namespace, name, ok := strings.Cut(cfg.StaticGroupsConfigMap, "/")
+			if !ok {
+				return nil, errors.New("either a static groups file or a configmap as <namespace>/<name> must be set")
+			}
+			return staticgroups.NewFromConfigMap(ctx, clientset, namespace, name, cfg.StaticGroupsConfigMapKey)
+		},
+	}
+}
+
+// newGroupProvider creates the configured group providers, each but static
+// with its own cache, chained in the configured mode. It returns nil if no
+// providers are configured.
+func newGroupProvider(ctx context.Context, cfg config, clientset kubernetes.Interface) (groups.Provider, error) {
+	names := cfg.GroupProviders
+	if len(names) == 0 && cfg.ApiImplementation != "" {
+		names = []string{cfg.ApiImplementation}
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	factories := groupProviderFactories(ctx, cfg, clientset)
+	var providers []groups.NamedProvider
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		factory, ok := factories[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown group provider %q", name)
+		}
+		p, err := factory()
+		if err != nil {
+			return nil, fmt.Errorf("create group provider %q: %w", name, err)
+		}
+		// The static provider is in memory, and caching it would keep
+		// removed memberships after a reload
+		if name != "static" {
+			p = groups.NewCache(
+				name,
+				p,
+				groups.WithTTL(cfg.GroupsCacheTtl),
+				groups.WithNegativeTTL(cfg.GroupsCacheNegativeTtl),
+				groups.WithStaleTTL(cfg.GroupsCacheStaleTtl),
+			)
+		}
+		providers = append(providers, groups.NamedProvider{Name: name, Provider: p})
+	}
+
+	if len(providers) == 1 {
+		return providers[0].Provider, nil
+	}
+	return groups.NewChain(groups.Mode(strings.ToLower(cfg.GroupProviderMode)), providers...)
+}
@@ -0,0 +1,213 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// MetadataFlavorHeader must be set to MetadataFlavor on requests to the token
+// endpoint, like for cloud metadata servers, so the token is not handed out
+// to requests forged by other means, e.g. a redirect.
+const (
+	MetadataFlavorHeader = "Metadata-Flavor"
+	MetadataFlavor       = "LabID"
+)
+
+// Agent keeps a fresh LabID token from a token source, written to a file and
+// served over HTTP.
+type Agent struct {
+	tokens        oauth2.TokenSource
+	tokenFile     string
+	refreshBefore time.Duration
+	minBackoff    time.Duration
+	maxBackoff    time.Duration
+
+	mu    sync.RWMutex
+	token *oauth2.Token
+}
+
+type optFunc func(*Agent)
+
+// WithTokenFile writes tokens to path, replacing the file atomically so
+// readers never see a partial token.
+func WithTokenFile(path string) optFunc {
+	return func(a *Agent) {
+		a.tokenFile = path
+	}
+}
+
+// WithRefreshBefore refreshes tokens this long before they expire, or at half
+// their remaining lifetime if that is later. It defaults to 5 minutes.
+func WithRefreshBefore(d time.Duration) optFunc {
+	return func(a *Agent) {
+		if d > 0 {
+			a.refreshBefore = d
+		}
+	}
+}
+
+// WithBackoff sets the delay after the first failed refresh, doubled for
+// every consecutive failure up to maxDelay. It defaults to 1 second and 1
+// minute.
+func WithBackoff(minDelay, maxDelay time.Duration) optFunc {
+	return func(a *Agent) {
+		if minDelay > 0 {
+			a.minBackoff = minDelay
+		}
+		if maxDelay > 0 {
+			a.maxBackoff = maxDelay
+		}
+	}
+}
+
+func New(tokens oauth2.TokenSource, opts ...optFunc) *Agent {
+	a := &Agent{
+		tokens:        tokens,
+		refreshBefore: 5 * time.Minute,
+		minBackoff:    time.Second,
+		maxBackoff:    time.Minute,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// Run refreshes the token until ctx is done. Failed refreshes are retried
+// with backoff, and the previous token is served until it expires.
+func (a *Agent) Run(ctx context.Context) error {
+	backoff := a.minBackoff
+	for {
+		var wait time.Duration
+		token, err := a.refresh()
+		if err != nil {
+			slog.Warn("refresh labid token", "error", err.Error(), "retryIn", backoff.String())
+			wait = backoff
+			backoff = min(backoff*2, a.maxBackoff)
+		} else {
+			backoff = a.minBackoff
+			wait = a.refreshIn(token)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (a *Agent) refreshIn(token *oauth2.Token) time.Duration {
+	if token.Expiry.IsZero() {
+		return a.maxBackoff
+	}
+	remaining := time.Until(token.Expiry)
+	return max(remaining-a.refreshBefore, remaining/2, a.minBackoff)
+}
+
+func (a *Agent) refresh() (*oauth2.Token, error) {
+	token, err := a.tokens.Token()
+	if err != nil {
+		return nil, err
+	}
+	if a.tokenFile != "" {
+		if err := writeFileAtomic(a.tokenFile, []byte(token.AccessToken)); err != nil {
+			return nil, fmt.Errorf("write token file: %w", err)
+		}
+	}
+	a.mu.Lock()
+	a.token = token
+	a.mu.Unlock()
+	return token, nil
+}
+
+// writeFileAtomic writes data to a temporary file in the directory of path and
+// renames it to path. The file is readable by all users, as the containers
+// sharing it may run as different users.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Token returns the current token, or nil if there is no unexpired token.
+func (a *Agent) Token() *oauth2.Token {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.token == nil || (!a.token.Expiry.IsZero() && !time.Now().Before(a.token.Expiry)) {
+		return nil
+	}
+	return a.token
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in,omitempty"`
+}
+
+// ServeToken serves the current token as JSON with access_token, token_type
+// and expires_in, or only the token with ?format=raw.
+func (a *Agent) ServeToken(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get(MetadataFlavorHeader) != MetadataFlavor {
+		http.Error(w, fmt.Sprintf("missing %s: %s header", MetadataFlavorHeader, MetadataFlavor), http.StatusForbidden)
+		return
+	}
+	token := a.Token()
+	if token == nil {
+		http.Error(w, "no token available yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set(MetadataFlavorHeader, MetadataFlavor)
+	w.Header().Set("Cache-Control", "no-store")
+	if r.URL.Query().Get("format") == "raw" {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(token.AccessToken))
+		return
+	}
+	res := tokenResponse{AccessToken: token.AccessToken, TokenType: token.Type()}
+	if !token.Expiry.IsZero() {
+		res.ExpiresIn = int64(time.Until(token.Expiry).Seconds())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// Readyz succeeds while there is an unexpired token.
+func (a *Agent) Readyz(w http.ResponseWriter, r *http.Request) {
+	if a.Token() == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
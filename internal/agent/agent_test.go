@@ -0,0 +1,138 @@
+package agent_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/statisticsnorway/labid/internal/agent"
+	"golang.org/x/oauth2"
+)
+
+// tokenSource fails the first failures calls, and then issues numbered tokens
+// valid for lifetime.
+type tokenSource struct {
+	mu       sync.Mutex
+	failures int
+	lifetime time.Duration
+	calls    int
+}
+
+func (ts *tokenSource) Token() (*oauth2.Token, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.calls++
+	if ts.calls <= ts.failures {
+		return nil, errors.New("labid unavailable")
+	}
+	return &oauth2.Token{
+		AccessToken: "token-" + string(rune('0'+ts.calls)),
+		TokenType:   "Bearer",
+		Expiry:      time.Now().Add(ts.lifetime),
+	}, nil
+}
+
+func (ts *tokenSource) Calls() int {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.calls
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRunRefreshesWithBackoff(t *testing.T) {
+	ts := &tokenSource{failures: 2, lifetime: 200 * time.Millisecond}
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	a := agent.New(ts,
+		agent.WithTokenFile(tokenFile),
+		agent.WithRefreshBefore(100*time.Millisecond),
+		agent.WithBackoff(10*time.Millisecond, 20*time.Millisecond),
+	)
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		a.Run(ctx)
+		close(done)
+	}()
+
+	// Two failures, then a token refreshed about every 100ms
+	waitFor(t, func() bool { return ts.Calls() >= 4 })
+	cancel()
+	<-done
+	data, err := os.ReadFile(tokenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token := string(data); token != "token-"+string(rune('0'+ts.Calls())) {
+		t.Fatalf("unexpected token in file %q", token)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(tokenFile))
+	if len(entries) != 1 {
+		t.Fatalf("expected only the token file, got %v", entries)
+	}
+}
+
+func TestServeToken(t *testing.T) {
+	ts := &tokenSource{lifetime: time.Hour}
+	a := agent.New(ts)
+	srv := httptest.NewServer(http.HandlerFunc(a.ServeToken))
+	defer srv.Close()
+
+	get := func(query string, flavor bool) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+query, nil)
+		if flavor {
+			req.Header.Set(agent.MetadataFlavorHeader, agent.MetadataFlavor)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { res.Body.Close() })
+		return res
+	}
+
+	if res := get("", true); res.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 before the first refresh, got %d", res.StatusCode)
+	}
+
+	go a.Run(t.Context())
+	waitFor(t, func() bool { return a.Token() != nil })
+
+	if res := get("", false); res.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403 without %s header, got %d", agent.MetadataFlavorHeader, res.StatusCode)
+	}
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(get("", true).Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.AccessToken != "token-1" || body.TokenType != "Bearer" || body.ExpiresIn < 3590 {
+		t.Fatalf("unexpected response %+v", body)
+	}
+
+	raw, err := io.ReadAll(get("?format=raw", true).Body)
+	if err != nil || string(raw) != "token-1" {
+		t.Fatalf("unexpected raw token %q, %v", raw, err)
+	}
+}
@@ -0,0 +1,142 @@
+package claims
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/lestrrat-go/jwx/v3/jwt"
+	"github.com/statisticsnorway/labid/internal/token"
+)
+
+type Type string
+
+const (
+	TypeString Type = "string"
+	TypeInt    Type = "int"
+	TypeFloat  Type = "float"
+	TypeBool   Type = "bool"
+	// TypeList is a comma separated list of strings
+	TypeList Type = "list"
+)
+
+var claimName = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9._-]*$`)
+
+// Annotations turns service account annotations starting with an allowed
+// prefix into claims, e.g. labid.dapla.ssb.no/claim-project: felles becomes
+// the claim project. Values are strings unless another type is declared for
+// the claim.
+type Annotations struct {
+	prefixes []string
+	types    map[string]Type
+	reserved []string
+}
+
+// NewAnnotations creates claims from annotations starting with prefixes.
+// types declares the type of claims by name. Annotations cannot set the
+// claims in token.ReservedClaims, nor any of reserved.
+func NewAnnotations(prefixes []string, types map[string]string, reserved ...string) (*Annotations, error) {
+	a := &Annotations{
+		prefixes: prefixes,
+		types:    map[string]Type{},
+		reserved: slices.Concat(token.ReservedClaims, reserved),
+	}
+	for _, p := range prefixes {
+		if p == "" {
+			return nil, errors.New("annotation claim prefix cannot be empty")
+		}
+	}
+	for name, typ := range types {
+		switch t := Type(typ); t {
+		case TypeString, TypeInt, TypeFloat, TypeBool, TypeList:
+			a.types[name] = t
+		default:
+			return nil, fmt.Errorf("claim %q has unknown type %q", name, typ)
+		}
+		if slices.Contains(a.reserved, name) {
+			return nil, fmt.Errorf("claim %q is reserved", name)
+		}
+	}
+	return a, nil
+}
+
+// Claims returns the claims set by annotations. The error wraps
+// token.ErrInvalidClaims if an annotation is not a valid claim.
+func (a *Annotations) Claims(annotations map[string]string) (map[string]any, error) {
+	claims := map[string]any{}
+	for key, value := range annotations {
+		var name string
+		for _, p := range a.prefixes {
+			if n, ok := strings.CutPrefix(key, p); ok {
+				name = n
+				break
+			}
+		}
+		if name == "" {
+			continue
+		}
+		if !claimName.MatchString(name) {
+			return nil, fmt.Errorf("%w: annotation %q is not a valid claim name", token.ErrInvalidClaims, key)
+		}
+		if slices.Contains(a.reserved, name) {
+			return nil, fmt.Errorf("%w: annotation %q sets reserved claim %q", token.ErrInvalidClaims, key, name)
+		}
+		if _, ok := claims[name]; ok {
+			return nil, fmt.Errorf("%w: claim %q is set by several annotations", token.ErrInvalidClaims, name)
+		}
+		v, err := a.parse(name, value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: annotation %q: %w", token.ErrInvalidClaims, key, err)
+		}
+		claims[name] = v
+	}
+	return claims, nil
+}
+
+func (a *Annotations) parse(name, value string) (any, error) {
+	switch typ := a.types[name]; typ {
+	case TypeString, "":
+		return value, nil
+	case TypeInt:
+		return strconv.ParseInt(value, 10, 64)
+	case TypeFloat:
+		return strconv.ParseFloat(value, 64)
+	case TypeBool:
+		return strconv.ParseBool(value)
+	case TypeList:
+		var items []string
+		for item := range strings.SplitSeq(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("unknown type %q", typ)
+	}
+}
+
+// AnnotationsPopulator sets the claims from the annotations of the service
+// account on every issued token.
+func AnnotationsPopulator(a *Annotations, getSa token.ServiceAccountGetter) token.ClaimsPopulator {
+	return func(_ context.Context, mc token.MapperContext) token.Mapper {
+		return func(ctx context.Context, builder *jwt.Builder) error {
+			sa, err := getSa(ctx, mc.ServiceAccount.Name, mc.ServiceAccount.Namespace)
+			if err != nil {
+				return fmt.Errorf("get service account: %w", err)
+			}
+			claims, err := a.Claims(sa.Annotations)
+			if err != nil {
+				return err
+			}
+			for name, v := range claims {
+				builder.Claim(name, v)
+			}
+			return nil
+		}
+	}
+}
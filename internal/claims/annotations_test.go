@@ -0,0 +1,64 @@
+package claims_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/statisticsnorway/labid/internal/claims"
+	"github.com/statisticsnorway/labid/internal/token"
+)
+
+func TestAnnotations(t *testing.T) {
+	a, err := claims.NewAnnotations(
+		[]string{"labid.dapla.ssb.no/claim-"},
+		map[string]string{"level": "int", "pii": "bool", "datasets": "list"},
+		"dapla.project",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := a.Claims(map[string]string{
+		"labid.dapla.ssb.no/claim-environment": "test",
+		"labid.dapla.ssb.no/claim-level":       "3",
+		"labid.dapla.ssb.no/claim-pii":         "true",
+		"labid.dapla.ssb.no/claim-datasets":    "a, b,",
+		"dapla.ssb.no/impersonate-group":       "dapla-felles-developers",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"environment": "test",
+		"level":       int64(3),
+		"pii":         true,
+		"datasets":    []string{"a", "b"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	for name, annotations := range map[string]map[string]string{
+		"reserved":          {"labid.dapla.ssb.no/claim-sub": "admin"},
+		"reserved by other": {"labid.dapla.ssb.no/claim-dapla.project": "x"},
+		"invalid name":      {"labid.dapla.ssb.no/claim-a b": "x"},
+		"invalid value":     {"labid.dapla.ssb.no/claim-level": "high"},
+	} {
+		if _, err := a.Claims(annotations); !errors.Is(err, token.ErrInvalidClaims) {
+			t.Errorf("%s: expected ErrInvalidClaims, got %v", name, err)
+		}
+	}
+}
+
+func TestNewAnnotationsErrors(t *testing.T) {
+	if _, err := claims.NewAnnotations([]string{""}, nil); err == nil {
+		t.Error("expected error for empty prefix")
+	}
+	if _, err := claims.NewAnnotations([]string{"p/"}, map[string]string{"a": "date"}); err == nil {
+		t.Error("expected error for unknown type")
+	}
+	if _, err := claims.NewAnnotations([]string{"p/"}, map[string]string{"aud": "string"}); err == nil {
+		t.Error("expected error for reserved claim")
+	}
+}
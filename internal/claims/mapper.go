@@ -0,0 +1,98 @@
+package claims
+
+import (
+	"context"
+	"fmt"
+
+	"cel.dev/cel-go/common/types"
+	"cel.dev/cel-go/common/types/ref"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+	"github.com/statisticsnorway/labid/internal/groups"
+	"github.com/statisticsnorway/labid/internal/identity"
+	"github.com/statisticsnorway/labid/internal/token"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Sources load the inputs of expressions from Kubernetes and the group
+// provider. They are only called if an expression uses them.
+type Sources struct {
+	GetServiceAccount token.ServiceAccountGetter
+	GetNamespace      identity.NamespaceGetter
+	// Groups may be nil, in which case groups is always empty
+	Groups groups.Provider
+}
+
+// Populator sets the claims of policy on every issued token.
+func Populator(policy *Policy, sources Sources) token.ClaimsPopulator {
+	return func(_ context.Context, mc token.MapperContext) token.Mapper {
+		return func(ctx context.Context, builder *jwt.Builder) error {
+			in := Input{
+				Kubernetes: Kubernetes{
+					Namespace:      mc.ServiceAccount.Namespace,
+					ServiceAccount: mc.ServiceAccount.Name,
+					Pod:            mc.Pod.Name,
+				},
+				Identity: Identity{
+					Kind:    string(mc.Identity.Kind),
+					Subject: mc.Identity.Subject,
+					Email:   mc.Identity.Email,
+				},
+				Audience: mc.Audience,
+				Scopes:   mc.Scopes,
+			}
+			vars := in.vars()
+			vars["serviceAccount"] = lazy(func() (any, error) {
+				sa, err := sources.GetServiceAccount(ctx, mc.ServiceAccount.Name, mc.ServiceAccount.Namespace)
+				if err != nil {
+					return nil, fmt.Errorf("get service account: %w", err)
+				}
+				return objectOf(sa.Name, sa.Namespace, sa.Labels, sa.Annotations).value(), nil
+			})
+			vars["ns"] = lazy(func() (any, error) {
+				ns, err := sources.GetNamespace(ctx, mc.ServiceAccount.Namespace)
+				if err != nil {
+					return nil, fmt.Errorf("get namespace: %w", err)
+				}
+				return namespaceOf(ns).value(), nil
+			})
+			vars["groups"] = lazy(func() (any, error) {
+				if sources.Groups == nil || mc.Identity.Kind != identity.KindUser {
+					return []string{}, nil
+				}
+				userGroups, err := sources.Groups.ListGroups(ctx, mc.Identity.Email)
+				if err != nil {
+					return nil, fmt.Errorf("list groups: %w", err)
+				}
+				return orEmpty(userGroups), nil
+			})
+
+			claims, err := policy.evaluate(vars)
+			if err != nil {
+				return err
+			}
+			for name, v := range claims {
+				builder.Claim(name, v)
+			}
+			return nil
+		}
+	}
+}
+
+// lazy loads a variable when an expression first uses it.
+func lazy(load func() (any, error)) func() ref.Val {
+	return func() ref.Val {
+		v, err := load()
+		if err != nil {
+			return types.WrapErr(err)
+		}
+		return types.DefaultTypeAdapter.NativeToValue(v)
+	}
+}
+
+func objectOf(name, namespace string, labels, annotations map[string]string) Object {
+	return Object{Name: name, Namespace: namespace, Labels: labels, Annotations: annotations}
+}
+
+func namespaceOf(ns *corev1.Namespace) Object {
+	return objectOf(ns.Name, "", ns.Labels, ns.Annotations)
+}
@@ -0,0 +1,292 @@
+package claims
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+
+	"cel.dev/cel-go/cel"
+	"cel.dev/cel-go/common/types"
+	"cel.dev/cel-go/common/types/ref"
+	"cel.dev/cel-go/ext"
+	"github.com/statisticsnorway/labid/internal/token"
+	"google.golang.org/protobuf/types/known/structpb"
+	"sigs.k8s.io/yaml"
+)
+
+// Upper bound on the cost of evaluating a single claim expression, to stop
+// runaway comprehensions.
+const costLimit = 100_000
+
+// Config is the format of the claim mapping file, in YAML or JSON:
+//
+//	claims:
+//	  - name: dapla.project
+//	    expression: serviceAccount.annotations[?"dapla.ssb.no/project"]
+//	tests:
+//	  - name: project from annotation
+//	    input:
+//	      serviceAccount:
+//	        annotations:
+//	          dapla.ssb.no/project: felles
+//	    claims:
+//	      dapla.project: felles
+type Config struct {
+	Claims []Claim `json:"claims"`
+	Tests  []Test  `json:"tests"`
+}
+
+// Claim is set to the result of a CEL expression. The claim is left out if
+// the result is null or an empty optional.
+type Claim struct {
+	Name       string `json:"name"`
+	Expression string `json:"expression"`
+}
+
+// Test is a test case for the claims, run when the policy is compiled.
+// Claims is the complete set of claims expected from input.
+type Test struct {
+	Name   string         `json:"name"`
+	Input  Input          `json:"input"`
+	Claims map[string]any `json:"claims"`
+}
+
+// Object is the metadata of a Kubernetes object.
+type Object struct {
+	Name        string            `json:"name"`
+	Namespace   string            `json:"namespace,omitempty"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+func (o Object) value() map[string]any {
+	return map[string]any{
+		"name":        o.Name,
+		"namespace":   o.Namespace,
+		"labels":      nonNil(o.Labels),
+		"annotations": nonNil(o.Annotations),
+	}
+}
+
+// Input is what claim expressions are evaluated over. Each field is a
+// variable of the same name in expressions:
+//
+//   - kubernetes: namespace, serviceAccount and pod from the subject token
+//   - identity: kind, subject and email of who the token is issued to
+//   - serviceAccount and ns: name, labels and annotations of the service
+//     account and namespace (namespace is reserved in CEL)
+//   - groups: all groups of the user, empty for services
+//   - audience and scopes: as requested
+type Input struct {
+	Kubernetes     Kubernetes `json:"kubernetes"`
+	Identity       Identity   `json:"identity"`
+	ServiceAccount Object     `json:"serviceAccount"`
+	Namespace      Object     `json:"ns"`
+	Groups         []string   `json:"groups"`
+	Audience       []string   `json:"audience"`
+	Scopes         []string   `json:"scopes"`
+}
+
+type Kubernetes struct {
+	Namespace      string `json:"namespace"`
+	ServiceAccount string `json:"serviceAccount"`
+	Pod            string `json:"pod"`
+}
+
+func (k Kubernetes) value() map[string]any {
+	return map[string]any{
+		"namespace":      k.Namespace,
+		"serviceAccount": k.ServiceAccount,
+		"pod":            k.Pod,
+	}
+}
+
+type Identity struct {
+	Kind    string `json:"kind"`
+	Subject string `json:"subject"`
+	Email   string `json:"email"`
+}
+
+func (i Identity) value() map[string]any {
+	return map[string]any{
+		"kind":    i.Kind,
+		"subject": i.Subject,
+		"email":   i.Email,
+	}
+}
+
+func (in Input) vars() map[string]any {
+	return map[string]any{
+		"kubernetes":     in.Kubernetes.value(),
+		"identity":       in.Identity.value(),
+		"serviceAccount": in.ServiceAccount.value(),
+		"ns":             in.Namespace.value(),
+		"groups":         orEmpty(in.Groups),
+		"audience":       orEmpty(in.Audience),
+		"scopes":         orEmpty(in.Scopes),
+	}
+}
+
+// nonNil returns an empty map for nil, since CEL has no nil maps.
+func nonNil[T any](m map[string]T) map[string]T {
+	if m == nil {
+		return map[string]T{}
+	}
+	return m
+}
+
+// orEmpty returns an empty slice for nil, since CEL has no nil lists.
+func orEmpty[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
+
+type compiledClaim struct {
+	name    string
+	program cel.Program
+}
+
+// Policy is a compiled claim mapping.
+type Policy struct {
+	claims []compiledClaim
+}
+
+// Parse parses and compiles a claim mapping file, see Config.
+func Parse(data []byte) (*Policy, error) {
+	var cfg Config
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return nil, fmt.Errorf("unmarshal claim mapping: %w", err)
+	}
+	return Compile(cfg)
+}
+
+// Compile type checks the claim expressions and runs the tests of cfg.
+func Compile(cfg Config) (*Policy, error) {
+	env, err := cel.NewEnv(
+		cel.OptionalTypes(),
+		ext.Strings(),
+		ext.Lists(),
+		ext.Sets(),
+		cel.Variable("kubernetes", cel.MapType(cel.StringType, cel.StringType)),
+		cel.Variable("identity", cel.MapType(cel.StringType, cel.StringType)),
+		cel.Variable("serviceAccount", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("ns", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("groups", cel.ListType(cel.StringType)),
+		cel.Variable("audience", cel.ListType(cel.StringType)),
+		cel.Variable("scopes", cel.ListType(cel.StringType)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create cel environment: %w", err)
+	}
+
+	p := &Policy{}
+	seen := map[string]bool{}
+	for _, c := range cfg.Claims {
+		if c.Name == "" {
+			return nil, errors.New("claim without name")
+		}
+		if slices.Contains(token.ReservedClaims, c.Name) {
+			return nil, fmt.Errorf("claim %q is reserved", c.Name)
+		}
+		if seen[c.Name] {
+			return nil, fmt.Errorf("claim %q is defined more than once", c.Name)
+		}
+		seen[c.Name] = true
+
+		ast, issues := env.Compile(c.Expression)
+		if issues.Err() != nil {
+			return nil, fmt.Errorf("compile claim %q: %w", c.Name, issues.Err())
+		}
+		program, err := env.Program(ast, cel.CostLimit(costLimit))
+		if err != nil {
+			return nil, fmt.Errorf("create program for claim %q: %w", c.Name, err)
+		}
+		p.claims = append(p.claims, compiledClaim{name: c.Name, program: program})
+	}
+
+	for _, t := range cfg.Tests {
+		if err := p.test(t); err != nil {
+			return nil, fmt.Errorf("test %q: %w", t.Name, err)
+		}
+	}
+
+	return p, nil
+}
+
+func (p *Policy) test(t Test) error {
+	got, err := p.Evaluate(t.Input)
+	if err != nil {
+		return err
+	}
+	// Round trip the expected claims through JSON, the same as the results
+	want := map[string]any{}
+	for name, v := range t.Claims {
+		pv, err := structpb.NewValue(v)
+		if err != nil {
+			return fmt.Errorf("expected claim %q: %w", name, err)
+		}
+		want[name] = pv.AsInterface()
+	}
+	if !reflect.DeepEqual(got, want) {
+		return fmt.Errorf("expected claims %v, got %v", want, got)
+	}
+	return nil
+}
+
+// Evaluate returns the claims for in.
+func (p *Policy) Evaluate(in Input) (map[string]any, error) {
+	return p.evaluate(in.vars())
+}
+
+// evaluate returns the claims for vars, where values can be lazily loaded
+// with func() ref.Val. Lazily loaded values are replaced in vars, so they are
+// only loaded once for all claims.
+func (p *Policy) evaluate(vars map[string]any) (map[string]any, error) {
+	claims := map[string]any{}
+	for _, c := range p.claims {
+		out, _, err := c.program.Eval(vars)
+		if err != nil {
+			return nil, fmt.Errorf("evaluate claim %q: %w", c.name, err)
+		}
+		v, ok, err := native(out)
+		if err != nil {
+			return nil, fmt.Errorf("convert claim %q: %w", c.name, err)
+		}
+		if ok {
+			claims[c.name] = v
+		}
+	}
+	return claims, nil
+}
+
+// native converts a CEL value to its JSON representation. It returns false
+// if the claim should be left out.
+func native(v ref.Val) (any, bool, error) {
+	if o, ok := v.(*types.Optional); ok {
+		if !o.HasValue() {
+			return nil, false, nil
+		}
+		v = o.GetValue()
+	}
+	if v == types.NullValue {
+		return nil, false, nil
+	}
+	pv, err := v.ConvertToNative(reflect.TypeFor[*structpb.Value]())
+	if err != nil {
+		return nil, false, err
+	}
+	return pv.(*structpb.Value).AsInterface(), true, nil
+}
+
+// Names returns the names of the claims, sorted.
+func (p *Policy) Names() []string {
+	var names []string
+	for _, c := range p.claims {
+		names = append(names, c.name)
+	}
+	slices.Sort(names)
+	return names
+}
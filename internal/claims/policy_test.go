@@ -0,0 +1,140 @@
+package claims_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v3/jwt"
+	"github.com/statisticsnorway/labid/internal/claims"
+	"github.com/statisticsnorway/labid/internal/groups"
+	"github.com/statisticsnorway/labid/internal/identity"
+	"github.com/statisticsnorway/labid/internal/token"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const policy = `
+claims:
+  - name: dapla.project
+    expression: serviceAccount.annotations[?"dapla.ssb.no/project"]
+  - name: dapla.environment
+    expression: 'ns.labels[?"environment"].orValue("prod")'
+  - name: dapla.admin
+    expression: '"dapla-admins" in groups'
+  - name: dapla.teams
+    expression: >-
+      groups.filter(g, g.endsWith("-developers"))
+      .map(g, g.substring(0, g.size() - size("-developers")))
+tests:
+  - name: annotated service account
+    input:
+      serviceAccount:
+        annotations:
+          dapla.ssb.no/project: felles
+      ns:
+        labels:
+          environment: test
+      groups: [dapla-felles-developers, dapla-admins]
+    claims:
+      dapla.project: felles
+      dapla.environment: test
+      dapla.admin: true
+      dapla.teams: [dapla-felles]
+  - name: missing annotation is left out
+    input: {}
+    claims:
+      dapla.environment: prod
+      dapla.admin: false
+      dapla.teams: []
+`
+
+func TestParseRunsTests(t *testing.T) {
+	if _, err := claims.Parse([]byte(policy)); err != nil {
+		t.Fatal(err)
+	}
+
+	failing := policy + `
+  - name: wrong expectation
+    input: {}
+    claims:
+      dapla.admin: true
+`
+	if _, err := claims.Parse([]byte(failing)); err == nil {
+		t.Fatal("expected failing test to fail parse")
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for name, cfg := range map[string]claims.Config{
+		"reserved claim": {Claims: []claims.Claim{{Name: "sub", Expression: `"x"`}}},
+		"syntax error":   {Claims: []claims.Claim{{Name: "a", Expression: `groups.`}}},
+		"type error":     {Claims: []claims.Claim{{Name: "a", Expression: `groups + 1`}}},
+		"unknown input":  {Claims: []claims.Claim{{Name: "a", Expression: `pod.name`}}},
+		"duplicate": {Claims: []claims.Claim{
+			{Name: "a", Expression: `1`},
+			{Name: "a", Expression: `2`},
+		}},
+	} {
+		if _, err := claims.Compile(cfg); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestPopulatorLoadsInputsLazily(t *testing.T) {
+	p, err := claims.Compile(claims.Config{Claims: []claims.Claim{
+		{Name: "dapla.project", Expression: `serviceAccount.annotations[?"dapla.ssb.no/project"]`},
+		{Name: "dapla.audience", Expression: `audience[0]`},
+		{Name: "dapla.owner", Expression: `identity.subject`},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var saGets int
+	populate := claims.Populator(p, claims.Sources{
+		GetServiceAccount: func(_ context.Context, name, namespace string) (*corev1.ServiceAccount, error) {
+			saGets++
+			return &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   namespace,
+				Annotations: map[string]string{"dapla.ssb.no/project": "felles"},
+			}}, nil
+		},
+		GetNamespace: func(context.Context, string) (*corev1.Namespace, error) {
+			return nil, errors.New("namespace should not be loaded")
+		},
+		Groups: groups.ProviderFunc(func(context.Context, string) ([]string, error) {
+			return nil, errors.New("groups should not be loaded")
+		}),
+	})
+
+	builder := jwt.NewBuilder()
+	mapper := populate(context.Background(), token.MapperContext{
+		Identity:       identity.Identity{Kind: identity.KindUser, Subject: "kari", Email: "kari@ssb.no"},
+		ServiceAccount: token.KubernetesMeta{Name: "default", Namespace: "user-ssb-kari"},
+		Audience:       []string{"dapla"},
+	})
+	if err := mapper(context.Background(), builder); err != nil {
+		t.Fatal(err)
+	}
+	tok, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"dapla.project": "felles", "dapla.audience": "dapla", "dapla.owner": "kari"}
+	got := map[string]string{}
+	for name := range want {
+		var v string
+		if err := tok.Get(name, &v); err != nil {
+			t.Fatalf("get claim %q: %v", name, err)
+		}
+		got[name] = v
+	}
+	if !reflect.DeepEqual(got, want) || saGets != 1 {
+		t.Fatalf("expected %v with 1 service account get, got %v with %d", want, got, saGets)
+	}
+}
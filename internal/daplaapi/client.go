@@ -2,22 +2,44 @@ package daplaapi
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/hasura/go-graphql-client"
-	"github.com/lestrrat-go/jwx/v3/jwt"
-	"github.com/statisticsnorway/labid/internal/token"
+	"github.com/statisticsnorway/labid/internal/groups"
 	"golang.org/x/oauth2"
 )
 
+var (
+	ErrTooManyGroups = errors.New("user is a member of more groups than the configured maximum")
+)
+
 type Client struct {
 	graphqlClient *graphql.Client
 	apiUrl        string
+	pageSize      int
+	maxGroups     int
 }
 
 type optFunc func(*Client)
 
+// WithPageSize sets how many groups are fetched per request.
+func WithPageSize(n int) optFunc {
+	return func(c *Client) {
+		c.pageSize = n
+	}
+}
+
+// WithMaxGroups sets the maximum number of groups fetched for a user. Users
+// with more groups fail with ErrTooManyGroups rather than getting an
+// incomplete list.
+func WithMaxGroups(n int) optFunc {
+	return func(c *Client) {
+		c.maxGroups = n
+	}
+}
+
 func NewClient(apiUrl, serviceAccountToken string, opts ...optFunc) *Client {
 	src := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: serviceAccountToken})
 	httpClient := oauth2.NewClient(context.Background(), src)
@@ -28,6 +50,8 @@ func NewClient(apiUrl, serviceAccountToken string, opts ...optFunc) *Client {
 	c := &Client{
 		graphqlClient: grahqlClient,
 		apiUrl:        apiUrl,
+		pageSize:      100,
+		maxGroups:     5000,
 	}
 
 	for _, opt := range opts {
@@ -37,41 +61,68 @@ func NewClient(apiUrl, serviceAccountToken string, opts ...optFunc) *Client {
 	return c
 }
 
+// ListGroups returns the groups of the user, or no groups if the Dapla API
+// does not know the user. Query failures wrap groups.ErrUnavailable.
 func (c *Client) ListGroups(ctx context.Context, userPrincipalEmail string) ([]string, error) {
-	var userGroupsQuery struct {
-		User struct {
-			Groups struct {
-				Nodes []struct {
-					Group struct {
-						Name graphql.String
+	var userGroups []string
+	var after *graphql.String
+	// Bounds the requests for a user, in addition to the empty page and
+	// cursor checks, should the API keep reporting more pages
+	maxPages := c.maxGroups/max(c.pageSize, 1) + 1
+	for page := 0; ; page++ {
+		if page == maxPages {
+			return nil, fmt.Errorf("list groups for %q: more than %d pages", userPrincipalEmail, maxPages)
+		}
+		var userGroupsQuery struct {
+			User *struct {
+				Groups struct {
+					Nodes []struct {
+						Group struct {
+							Name graphql.String
+						}
 					}
-				}
-			} `graphql:"groups(first: 1000)"`
-		} `graphql:"user(email: $email)"`
-	}
-	variables := map[string]interface{}{
-		"email": graphql.String(userPrincipalEmail),
-	}
-
-	err := c.graphqlClient.Query(ctx, &userGroupsQuery, variables)
-	if err != nil {
-		return nil, fmt.Errorf("query for dapla api failed %w", err)
-	}
-	var groups []string
-	for _, node := range userGroupsQuery.User.Groups.Nodes {
-		groups = append(groups, string(node.Group.Name))
-	}
-	return groups, nil
-}
+					PageInfo struct {
+						HasNextPage graphql.Boolean
+						EndCursor   graphql.String
+					}
+				} `graphql:"groups(first: $first, after: $after)"`
+			} `graphql:"user(email: $email)"`
+		}
+		variables := map[string]interface{}{
+			"email": graphql.String(userPrincipalEmail),
+			"first": graphql.Int(c.pageSize),
+			"after": after,
+		}
 
-func (c *Client) AllGroupsPopulator(ctx context.Context, username string) token.Mapper {
-	return func(ctx context.Context, builder *jwt.Builder) error {
-		groups, err := c.ListGroups(ctx, fmt.Sprintf("%s@ssb.no", username))
+		err := c.graphqlClient.Query(ctx, &userGroupsQuery, variables)
 		if err != nil {
-			return err
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("query dapla api: %w", err)
+			}
+			return nil, fmt.Errorf("query dapla api: %w: %w", groups.ErrUnavailable, err)
+		}
+		if userGroupsQuery.User == nil {
+			return nil, nil
 		}
 
-		builder.Claim("dapla.groups", groups)
-		return nil
+		for _, node := range userGroupsQuery.User.Groups.Nodes {
+			userGroups = append(userGroups, string(node.Group.Name))
+		}
+		if len(userGroups) > c.maxGroups {
+			return nil, fmt.Errorf("list groups for %q: %w (%d)", userPrincipalEmail, ErrTooManyGroups, c.maxGroups)
+		}
+
+		pageInfo := userGroupsQuery.User.Groups.PageInfo
+		if !pageInfo.HasNextPage {
+			return userGroups, nil
+		}
+		if len(userGroupsQuery.User.Groups.Nodes) == 0 {
+			return nil, fmt.Errorf("list groups for %q: dapla api returned an empty page with more pages", userPrincipalEmail)
+		}
+		cursor := pageInfo.EndCursor
+		if after != nil && *after == cursor {
+			return nil, fmt.Errorf("list groups for %q: dapla api did not advance the cursor %q", userPrincipalEmail, cursor)
+		}
+		after = &cursor
 	}
 }
@@ -0,0 +1,147 @@
+package daplaapi_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/statisticsnorway/labid/internal/daplaapi"
+	"github.com/statisticsnorway/labid/internal/groups"
+)
+
+type graphqlRequest struct {
+	Query     string         `json:"query"`
+	Variables map[string]any `json:"variables"`
+}
+
+// GraphqlStandIn serves the user groups query of the Dapla API from
+// memberships, using the index of the next group as cursor.
+func GraphqlStandIn(t *testing.T, memberships map[string][]string, requests *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		var req graphqlRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode graphql request: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if !strings.Contains(req.Query, "pageInfo{hasNextPage,endCursor}") {
+			t.Errorf("query does not request page info: %s", req.Query)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		userGroups, ok := memberships[req.Variables["email"].(string)]
+		if !ok {
+			fmt.Fprint(w, `{"data":{"user":null}}`)
+			return
+		}
+
+		start := 0
+		if after, ok := req.Variables["after"].(string); ok {
+			start, _ = strconv.Atoi(after)
+		}
+		end := min(start+int(req.Variables["first"].(float64)), len(userGroups))
+
+		nodes := []map[string]any{}
+		for _, g := range userGroups[start:end] {
+			nodes = append(nodes, map[string]any{"group": map[string]any{"name": g}})
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"data": map[string]any{
+				"user": map[string]any{
+					"groups": map[string]any{
+						"nodes": nodes,
+						"pageInfo": map[string]any{
+							"hasNextPage": end < len(userGroups),
+							"endCursor":   strconv.Itoa(end),
+						},
+					},
+				},
+			},
+		})
+	}))
+}
+
+func TestListGroupsPaginates(t *testing.T) {
+	want := []string{"a", "b", "c", "d", "e"}
+	var requests int
+	srv := GraphqlStandIn(t, map[string][]string{"kari@ssb.no": want}, &requests)
+	defer srv.Close()
+
+	c := daplaapi.NewClient(srv.URL, "token", daplaapi.WithPageSize(2))
+	got, err := c.ListGroups(context.Background(), "kari@ssb.no")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	if requests != 3 {
+		t.Fatalf("expected 3 requests, got %d", requests)
+	}
+}
+
+func TestListGroupsMaxGroups(t *testing.T) {
+	var requests int
+	srv := GraphqlStandIn(t, map[string][]string{"kari@ssb.no": {"a", "b", "c", "d", "e"}}, &requests)
+	defer srv.Close()
+
+	c := daplaapi.NewClient(srv.URL, "token", daplaapi.WithPageSize(2), daplaapi.WithMaxGroups(3))
+	if _, err := c.ListGroups(context.Background(), "kari@ssb.no"); !errors.Is(err, daplaapi.ErrTooManyGroups) {
+		t.Fatalf("expected ErrTooManyGroups, got %v", err)
+	}
+}
+
+func TestListGroupsUnknownUser(t *testing.T) {
+	var requests int
+	srv := GraphqlStandIn(t, map[string][]string{}, &requests)
+	defer srv.Close()
+
+	c := daplaapi.NewClient(srv.URL, "token")
+	got, err := c.ListGroups(context.Background(), "ola@ssb.no")
+	if err != nil || len(got) != 0 {
+		t.Fatalf("expected no groups, got %v, %v", got, err)
+	}
+}
+
+func TestListGroupsStopsOnBrokenPagination(t *testing.T) {
+	for name, page := range map[string]string{
+		"empty page":   `{"data":{"user":{"groups":{"nodes":[],"pageInfo":{"hasNextPage":true,"endCursor":"1"}}}}}`,
+		"stuck cursor": `{"data":{"user":{"groups":{"nodes":[{"group":{"name":"a"}}],"pageInfo":{"hasNextPage":true,"endCursor":"1"}}}}}`,
+	} {
+		var requests int
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, page)
+		}))
+
+		c := daplaapi.NewClient(srv.URL, "token")
+		if _, err := c.ListGroups(context.Background(), "kari@ssb.no"); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+		if requests > 2 {
+			t.Errorf("%s: expected at most 2 requests, got %d", name, requests)
+		}
+		srv.Close()
+	}
+}
+
+func TestListGroupsUnavailable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	c := daplaapi.NewClient(srv.URL, "token")
+	if _, err := c.ListGroups(context.Background(), "kari@ssb.no"); !errors.Is(err, groups.ErrUnavailable) {
+		t.Fatalf("expected ErrUnavailable, got %v", err)
+	}
+}
@@ -0,0 +1,200 @@
+package extauthz
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/lestrrat-go/jwx/v3/jwt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// TokenVerifier validates the signature, issuer and expiry of a LabID token.
+type TokenVerifier func(rawToken string) (jwt.Token, error)
+
+// DefaultClaimHeaders are the claims forwarded upstream by default, by the
+// header they are forwarded as.
+var DefaultClaimHeaders = map[string]string{
+	"sub":          "x-labid-subject",
+	"dapla.group":  "x-labid-group",
+	"dapla.groups": "x-labid-groups",
+}
+
+var checks metric.Int64Counter
+
+func init() {
+	var err error
+	checks, err = otel.Meter("github.com/statisticsnorway/labid/internal/extauthz").Int64Counter(
+		"labid.extauthz.checks",
+		metric.WithDescription("Number of ext_authz checks, by the check that failed or ok"),
+		metric.WithUnit("{check}"),
+	)
+	if err != nil {
+		otel.Handle(err)
+	}
+}
+
+// Authorizer checks LabID tokens on behalf of Envoy.
+type Authorizer struct {
+	verify        TokenVerifier
+	audiences     []string
+	requiredGroup string
+	claimHeaders  map[string]string
+}
+
+type optFunc func(*Authorizer)
+
+// WithAudiences requires tokens to have one of audiences. Empty audiences
+// accept any audience.
+func WithAudiences(audiences []string) optFunc {
+	return func(a *Authorizer) {
+		a.audiences = audiences
+	}
+}
+
+// WithRequiredGroup requires tokens to have group, as either dapla.group or
+// one of dapla.groups, unless another group is required for the request.
+// An empty group requires none.
+func WithRequiredGroup(group string) optFunc {
+	return func(a *Authorizer) {
+		a.requiredGroup = group
+	}
+}
+
+// WithClaimHeaders forwards the claims of allowed requests upstream, as the
+// header each claim maps to. Lists are joined by commas. Nil headers keep
+// DefaultClaimHeaders.
+func WithClaimHeaders(headers map[string]string) optFunc {
+	return func(a *Authorizer) {
+		if headers != nil {
+			a.claimHeaders = headers
+		}
+	}
+}
+
+func NewAuthorizer(verify TokenVerifier, opts ...optFunc) *Authorizer {
+	a := &Authorizer{
+		verify:       verify,
+		claimHeaders: DefaultClaimHeaders,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// Result is the outcome of a check.
+type Result struct {
+	// Status is http.StatusOK if the request is allowed
+	Status int
+	// Check is the check that failed, or ok
+	Check string
+	// Reason describes why the request was denied
+	Reason string
+	// Headers are the headers to forward upstream, or to return on denial
+	Headers map[string]string
+	// RemoveHeaders are the claim headers of allowed requests whose claim is
+	// not in the token, which must be removed so clients cannot set them
+	RemoveHeaders []string
+}
+
+func (r Result) Allowed() bool {
+	return r.Status == http.StatusOK
+}
+
+func deny(status int, check, reason string) Result {
+	errorCode := "invalid_token"
+	if status == http.StatusForbidden {
+		errorCode = "insufficient_scope"
+	}
+	return Result{
+		Status: status,
+		Check:  check,
+		Reason: reason,
+		Headers: map[string]string{
+			"www-authenticate": fmt.Sprintf(`Bearer error=%q, error_description=%q`, errorCode, reason),
+		},
+	}
+}
+
+// Check checks the authorization header of a request. requiredGroup
+// overrides the group configured with WithRequiredGroup if not empty.
+func (a *Authorizer) Check(ctx context.Context, authorization, requiredGroup string) Result {
+	res := a.check(authorization, requiredGroup)
+	checks.Add(ctx, 1, metric.WithAttributes(attribute.String("check", res.Check)))
+	return res
+}
+
+func (a *Authorizer) check(authorization, requiredGroup string) Result {
+	rawToken, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok || rawToken == "" {
+		res := deny(http.StatusUnauthorized, "token", "bearer token is required")
+		res.Headers["www-authenticate"] = "Bearer"
+		return res
+	}
+	token, err := a.verify(rawToken)
+	if err != nil {
+		return deny(http.StatusUnauthorized, "token", err.Error())
+	}
+
+	if len(a.audiences) > 0 {
+		audience, _ := token.Audience()
+		if !slices.ContainsFunc(audience, func(aud string) bool { return slices.Contains(a.audiences, aud) }) {
+			return deny(http.StatusUnauthorized, "audience", fmt.Sprintf("token audience %v is not accepted", audience))
+		}
+	}
+
+	if requiredGroup == "" {
+		requiredGroup = a.requiredGroup
+	}
+	if requiredGroup != "" && !hasGroup(token, requiredGroup) {
+		return deny(http.StatusForbidden, "group", fmt.Sprintf("token does not have group %q", requiredGroup))
+	}
+
+	headers := map[string]string{}
+	var remove []string
+	for claim, header := range a.claimHeaders {
+		var v any
+		if err := token.Get(claim, &v); err != nil {
+			remove = append(remove, header)
+			continue
+		}
+		headers[header] = headerValue(v)
+	}
+	slices.Sort(remove)
+	return Result{Status: http.StatusOK, Check: "ok", Headers: headers, RemoveHeaders: remove}
+}
+
+func hasGroup(token jwt.Token, group string) bool {
+	var current string
+	if err := token.Get("dapla.group", &current); err == nil && current == group {
+		return true
+	}
+	var groups []any
+	if err := token.Get("dapla.groups", &groups); err == nil {
+		return slices.Contains(groups, any(group))
+	}
+	return false
+}
+
+func headerValue(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, headerValue(item))
+		}
+		return strings.Join(items, ",")
+	case []string:
+		return strings.Join(v, ",")
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -0,0 +1,76 @@
+package extauthz
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"slices"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+)
+
+// RequiredGroupExtension is the context extension of the Envoy ext_authz
+// filter which overrides the group of WithRequiredGroup, e.g. per route.
+const RequiredGroupExtension = "labid_required_group"
+
+var _ authv3.AuthorizationServer = (*grpcServer)(nil)
+
+type grpcServer struct {
+	authv3.UnimplementedAuthorizationServer
+	authorizer *Authorizer
+}
+
+// NewGrpcServer implements the Envoy ext_authz gRPC service. Allowed requests
+// get the claim headers, overwriting any sent by the client, and claim
+// headers for claims the token does not have are removed.
+func NewGrpcServer(a *Authorizer) authv3.AuthorizationServer {
+	return &grpcServer{authorizer: a}
+}
+
+func (s *grpcServer) Check(ctx context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	attrs := req.GetAttributes()
+	// Envoy lower cases header names
+	authorization := attrs.GetRequest().GetHttp().GetHeaders()["authorization"]
+	requiredGroup := attrs.GetContextExtensions()[RequiredGroupExtension]
+
+	res := s.authorizer.Check(ctx, authorization, requiredGroup)
+	headers := headerOptions(res.Headers)
+	if res.Allowed() {
+		return &authv3.CheckResponse{
+			Status: &status.Status{Code: int32(codes.OK)},
+			HttpResponse: &authv3.CheckResponse_OkResponse{
+				OkResponse: &authv3.OkHttpResponse{Headers: headers, HeadersToRemove: res.RemoveHeaders},
+			},
+		}, nil
+	}
+
+	code, httpStatus := codes.Unauthenticated, typev3.StatusCode_Unauthorized
+	if res.Status == http.StatusForbidden {
+		code, httpStatus = codes.PermissionDenied, typev3.StatusCode_Forbidden
+	}
+	return &authv3.CheckResponse{
+		Status: &status.Status{Code: int32(code), Message: res.Reason},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{
+			DeniedResponse: &authv3.DeniedHttpResponse{
+				Status:  &typev3.HttpStatus{Code: httpStatus},
+				Headers: headers,
+				Body:    res.Reason,
+			},
+		},
+	}, nil
+}
+
+func headerOptions(headers map[string]string) []*corev3.HeaderValueOption {
+	var options []*corev3.HeaderValueOption
+	for _, k := range slices.Sorted(maps.Keys(headers)) {
+		options = append(options, &corev3.HeaderValueOption{
+			Header:       &corev3.HeaderValue{Key: k, Value: headers[k]},
+			AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+		})
+	}
+	return options
+}
@@ -0,0 +1,51 @@
+package extauthz
+
+import (
+	"net/http"
+	"strings"
+)
+
+// HTTPHandler implements the Envoy ext_authz HTTP service, for the path
+// prefix prefix, e.g. /ext_authz. Envoy appends the path of the original
+// request to the path prefix of the ext_authz configuration, which should
+// be one of:
+//
+//   - <prefix>/check: the group of WithRequiredGroup is required
+//   - <prefix>/group/<group>: group is required
+//
+// Allowed requests get status 200 with the claim headers, which must be
+// listed in the allowed upstream headers of Envoy. Claim headers for claims
+// the token does not have are empty. Denied requests get 401
+// or 403, which Envoy returns to the client.
+func (a *Authorizer) HTTPHandler(prefix string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, ok := strings.CutPrefix(r.URL.Path, prefix)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		var requiredGroup string
+		switch segments := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 3); {
+		case segments[0] == "check":
+		case segments[0] == "group" && len(segments) > 1 && segments[1] != "":
+			requiredGroup = segments[1]
+		default:
+			http.Error(w, "path must start with /check or /group/<group>", http.StatusNotFound)
+			return
+		}
+
+		res := a.Check(r.Context(), r.Header.Get("Authorization"), requiredGroup)
+		for k, v := range res.Headers {
+			w.Header().Set(k, v)
+		}
+		// Envoy only overwrites the allowed upstream headers it gets, so claim
+		// headers without a claim are sent empty to replace any from the client
+		for _, k := range res.RemoveHeaders {
+			w.Header().Set(k, "")
+		}
+		w.WriteHeader(res.Status)
+		if !res.Allowed() {
+			w.Write([]byte(res.Reason))
+		}
+	})
+}
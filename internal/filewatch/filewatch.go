@@ -0,0 +1,60 @@
+package filewatch
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// Watcher detects changes to the content of a set of files. Content is
+// compared rather than modification times, since Kubernetes updates mounted
+// Secrets and ConfigMaps by swapping symlinks.
+type Watcher struct {
+	paths []string
+	last  []byte
+}
+
+// New records the current content of paths. Create the watcher before
+// loading the files, so changes made in between are not missed.
+func New(paths ...string) *Watcher {
+	return &Watcher{
+		paths: paths,
+		last:  digest(paths...),
+	}
+}
+
+// Poll calls onChange whenever the content of any of the files changes,
+// checking every interval until ctx is cancelled.
+func (w *Watcher) Poll(ctx context.Context, interval time.Duration, onChange func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			current := digest(w.paths...)
+			if !bytes.Equal(current, w.last) {
+				w.last = current
+				onChange()
+			}
+		}
+	}
+}
+
+func digest(paths ...string) []byte {
+	h := sha256.New()
+	for _, p := range paths {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			slog.Warn("read watched file", "path", p, "error", err.Error())
+			continue
+		}
+		h.Write(b)
+	}
+	return h.Sum(nil)
+}
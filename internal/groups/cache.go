@@ -0,0 +1,188 @@
+package groups
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"golang.org/x/sync/singleflight"
+)
+
+var cacheLookups metric.Int64Counter
+
+func init() {
+	var err error
+	cacheLookups, err = otel.Meter("github.com/statisticsnorway/labid/internal/groups").Int64Counter(
+		"labid.groups.cache.lookups",
+		metric.WithDescription("Number of group cache lookups by result"),
+		metric.WithUnit("{lookup}"),
+	)
+	if err != nil {
+		otel.Handle(err)
+	}
+}
+
+type cacheEntry struct {
+	groups   []string
+	notFound bool
+	fetched  time.Time
+}
+
+// Cache is a Provider which caches the results of another Provider.
+//
+// Groups are cached for the TTL, and unknown users for the negative TTL. For
+// the stale TTL after an entry has expired it is still served while it is
+// refreshed in the background, so lookups keep working while the upstream is
+// unavailable. Concurrent lookups for the same user share one upstream call.
+type Cache struct {
+	provider     Provider
+	name         string
+	ttl          time.Duration
+	negativeTTL  time.Duration
+	staleTTL     time.Duration
+	fetchTimeout time.Duration
+	maxEntries   int
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	flight  singleflight.Group
+}
+
+type cacheOptFunc func(*Cache)
+
+func WithTTL(ttl time.Duration) cacheOptFunc {
+	return func(c *Cache) {
+		c.ttl = ttl
+	}
+}
+
+func WithNegativeTTL(ttl time.Duration) cacheOptFunc {
+	return func(c *Cache) {
+		c.negativeTTL = ttl
+	}
+}
+
+func WithStaleTTL(ttl time.Duration) cacheOptFunc {
+	return func(c *Cache) {
+		c.staleTTL = ttl
+	}
+}
+
+func WithMaxEntries(n int) cacheOptFunc {
+	return func(c *Cache) {
+		c.maxEntries = n
+	}
+}
+
+// NewCache wraps p in a cache. name identifies the provider in metrics.
+func NewCache(name string, p Provider, opts ...cacheOptFunc) *Cache {
+	c := &Cache{
+		provider:     p,
+		name:         name,
+		ttl:          5 * time.Minute,
+		negativeTTL:  time.Minute,
+		staleTTL:     time.Hour,
+		fetchTimeout: 10 * time.Second,
+		maxEntries:   10000,
+		entries:      map[string]*cacheEntry{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func (c *Cache) ListGroups(ctx context.Context, userPrincipalEmail string) ([]string, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	e, ok := c.entries[userPrincipalEmail]
+	c.mu.Unlock()
+
+	if ok {
+		age := now.Sub(e.fetched)
+		switch {
+		case e.notFound && age < c.negativeTTL:
+			c.record(ctx, "negative_hit")
+			return nil, ErrUserNotFound
+		case !e.notFound && age < c.ttl:
+			c.record(ctx, "hit")
+			return slices.Clone(e.groups), nil
+		case !e.notFound && age < c.ttl+c.staleTTL:
+			c.record(ctx, "stale")
+			go func() {
+				if _, err := c.fetch(context.WithoutCancel(ctx), userPrincipalEmail); err != nil {
+					slog.Warn("refresh stale groups", "provider", c.name, "error", err.Error())
+				}
+			}()
+			return slices.Clone(e.groups), nil
+		}
+	}
+
+	c.record(ctx, "miss")
+	return c.fetch(ctx, userPrincipalEmail)
+}
+
+// fetch looks up the groups upstream, de-duplicating concurrent calls. The
+// upstream call is detached from the cancellation of ctx since its result
+// may be shared with other callers.
+func (c *Cache) fetch(ctx context.Context, userPrincipalEmail string) ([]string, error) {
+	ch := c.flight.DoChan(userPrincipalEmail, func() (any, error) {
+		fetchCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.fetchTimeout)
+		defer cancel()
+
+		groups, err := c.provider.ListGroups(fetchCtx, userPrincipalEmail)
+		switch {
+		case err == nil:
+			c.store(userPrincipalEmail, &cacheEntry{groups: groups, fetched: time.Now()})
+		case errors.Is(err, ErrUserNotFound):
+			c.store(userPrincipalEmail, &cacheEntry{notFound: true, fetched: time.Now()})
+		}
+		return groups, err
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return slices.Clone(res.Val.([]string)), nil
+	}
+}
+
+func (c *Cache) store(key string, e *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.entries[key]; !exists && len(c.entries) >= c.maxEntries {
+		now := time.Now()
+		for k, old := range c.entries {
+			if now.Sub(old.fetched) > c.ttl+c.staleTTL {
+				delete(c.entries, k)
+			}
+		}
+		// Still full, evict an arbitrary entry
+		for k := range c.entries {
+			if len(c.entries) < c.maxEntries {
+				break
+			}
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = e
+}
+
+func (c *Cache) record(ctx context.Context, result string) {
+	cacheLookups.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("provider", c.name),
+		attribute.String("result", result),
+	))
+}
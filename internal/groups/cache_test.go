@@ -0,0 +1,125 @@
+package groups_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/statisticsnorway/labid/internal/groups"
+)
+
+type countingProvider struct {
+	calls atomic.Int32
+	fn    groups.ProviderFunc
+}
+
+func (p *countingProvider) ListGroups(ctx context.Context, email string) ([]string, error) {
+	p.calls.Add(1)
+	return p.fn(ctx, email)
+}
+
+func TestCacheHit(t *testing.T) {
+	p := &countingProvider{fn: func(context.Context, string) ([]string, error) {
+		return []string{"a", "b"}, nil
+	}}
+	c := groups.NewCache("test", p)
+
+	for range 3 {
+		got, err := c.ListGroups(context.Background(), "kari@ssb.no")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, []string{"a", "b"}) {
+			t.Fatalf("unexpected groups %v", got)
+		}
+	}
+	if calls := p.calls.Load(); calls != 1 {
+		t.Fatalf("expected 1 upstream call, got %d", calls)
+	}
+}
+
+func TestCacheNegative(t *testing.T) {
+	p := &countingProvider{fn: func(context.Context, string) ([]string, error) {
+		return nil, groups.ErrUserNotFound
+	}}
+	c := groups.NewCache("test", p)
+
+	for range 2 {
+		if _, err := c.ListGroups(context.Background(), "unknown@ssb.no"); !errors.Is(err, groups.ErrUserNotFound) {
+			t.Fatalf("expected ErrUserNotFound, got %v", err)
+		}
+	}
+	if calls := p.calls.Load(); calls != 1 {
+		t.Fatalf("expected 1 upstream call, got %d", calls)
+	}
+}
+
+func TestCacheDoesNotCacheErrors(t *testing.T) {
+	p := &countingProvider{fn: func(context.Context, string) ([]string, error) {
+		return nil, errors.New("upstream unavailable")
+	}}
+	c := groups.NewCache("test", p)
+
+	for range 2 {
+		if _, err := c.ListGroups(context.Background(), "kari@ssb.no"); err == nil {
+			t.Fatal("expected error")
+		}
+	}
+	if calls := p.calls.Load(); calls != 2 {
+		t.Fatalf("expected 2 upstream calls, got %d", calls)
+	}
+}
+
+func TestCacheServesStaleWhenUpstreamFails(t *testing.T) {
+	var down atomic.Bool
+	p := &countingProvider{fn: func(context.Context, string) ([]string, error) {
+		if down.Load() {
+			return nil, errors.New("upstream unavailable")
+		}
+		return []string{"a"}, nil
+	}}
+	c := groups.NewCache("test", p, groups.WithTTL(time.Millisecond), groups.WithStaleTTL(time.Hour))
+
+	if _, err := c.ListGroups(context.Background(), "kari@ssb.no"); err != nil {
+		t.Fatal(err)
+	}
+	down.Store(true)
+	time.Sleep(5 * time.Millisecond)
+
+	got, err := c.ListGroups(context.Background(), "kari@ssb.no")
+	if err != nil {
+		t.Fatalf("expected stale groups, got error %v", err)
+	}
+	if !slices.Equal(got, []string{"a"}) {
+		t.Fatalf("unexpected groups %v", got)
+	}
+}
+
+func TestCacheDeduplicatesConcurrentLookups(t *testing.T) {
+	release := make(chan struct{})
+	p := &countingProvider{fn: func(context.Context, string) ([]string, error) {
+		<-release
+		return []string{"a"}, nil
+	}}
+	c := groups.NewCache("test", p)
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Go(func() {
+			if _, err := c.ListGroups(context.Background(), "kari@ssb.no"); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls := p.calls.Load(); calls != 1 {
+		t.Fatalf("expected 1 upstream call, got %d", calls)
+	}
+}
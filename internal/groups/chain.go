@@ -0,0 +1,186 @@
+package groups
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+type Mode string
+
+const (
+	// ModeFallback uses the first provider which does not fail. A provider
+	// which does not know the user is authoritative and not fallen back from.
+	ModeFallback Mode = "fallback"
+	// ModeUnion merges the groups of all providers, and fails if any fails.
+	ModeUnion Mode = "union"
+	// ModeShadow only uses the first provider, but also queries the others in
+	// the background and logs any differences.
+	ModeShadow Mode = "shadow"
+)
+
+var shadowDiscrepancies metric.Int64Counter
+
+func init() {
+	var err error
+	shadowDiscrepancies, err = otel.Meter("github.com/statisticsnorway/labid/internal/groups").Int64Counter(
+		"labid.groups.shadow.discrepancies",
+		metric.WithDescription("Number of shadow lookups which differed from the primary provider"),
+		metric.WithUnit("{lookup}"),
+	)
+	if err != nil {
+		otel.Handle(err)
+	}
+}
+
+type NamedProvider struct {
+	Name string
+	Provider
+}
+
+// Chain is a Provider querying an ordered list of providers.
+type Chain struct {
+	mode          Mode
+	providers     []NamedProvider
+	shadowTimeout time.Duration
+}
+
+func NewChain(mode Mode, providers ...NamedProvider) (*Chain, error) {
+	if len(providers) == 0 {
+		return nil, errors.New("provider chain must have at least one provider")
+	}
+	switch mode {
+	case ModeFallback, ModeUnion, ModeShadow:
+	default:
+		return nil, fmt.Errorf("unknown provider chain mode %q", mode)
+	}
+	return &Chain{
+		mode:          mode,
+		providers:     providers,
+		shadowTimeout: 30 * time.Second,
+	}, nil
+}
+
+func (c *Chain) ListGroups(ctx context.Context, userPrincipalEmail string) ([]string, error) {
+	switch c.mode {
+	case ModeUnion:
+		return c.union(ctx, userPrincipalEmail)
+	case ModeShadow:
+		return c.shadow(ctx, userPrincipalEmail)
+	default:
+		return c.fallback(ctx, userPrincipalEmail)
+	}
+}
+
+func (c *Chain) fallback(ctx context.Context, userPrincipalEmail string) ([]string, error) {
+	var errs []error
+	for _, p := range c.providers {
+		groups, err := p.ListGroups(ctx, userPrincipalEmail)
+		if err == nil || errors.Is(err, ErrUserNotFound) || ctx.Err() != nil {
+			return groups, err
+		}
+		slog.Warn("group provider failed, falling back", "provider", p.Name, "error", err.Error())
+		errs = append(errs, fmt.Errorf("%s: %w", p.Name, err))
+	}
+	return nil, errors.Join(errs...)
+}
+
+type result struct {
+	groups []string
+	err    error
+}
+
+func (c *Chain) queryAll(ctx context.Context, providers []NamedProvider, userPrincipalEmail string) []result {
+	results := make([]result, len(providers))
+	var wg sync.WaitGroup
+	for i, p := range providers {
+		wg.Go(func() {
+			groups, err := p.ListGroups(ctx, userPrincipalEmail)
+			results[i] = result{groups, err}
+		})
+	}
+	wg.Wait()
+	return results
+}
+
+func (c *Chain) union(ctx context.Context, userPrincipalEmail string) ([]string, error) {
+	var union []string
+	found := false
+	for i, res := range c.queryAll(ctx, c.providers, userPrincipalEmail) {
+		switch {
+		case errors.Is(res.err, ErrUserNotFound):
+			continue
+		case res.err != nil:
+			return nil, fmt.Errorf("%s: %w", c.providers[i].Name, res.err)
+		}
+		found = true
+		for _, g := range res.groups {
+			if !slices.Contains(union, g) {
+				union = append(union, g)
+			}
+		}
+	}
+	if !found {
+		return nil, ErrUserNotFound
+	}
+	return union, nil
+}
+
+func (c *Chain) shadow(ctx context.Context, userPrincipalEmail string) ([]string, error) {
+	primary := c.providers[0]
+	groups, err := primary.ListGroups(ctx, userPrincipalEmail)
+
+	go func() {
+		shadowCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.shadowTimeout)
+		defer cancel()
+		for i, res := range c.queryAll(shadowCtx, c.providers[1:], userPrincipalEmail) {
+			c.compare(shadowCtx, primary.Name, c.providers[i+1].Name, groups, err, res)
+		}
+	}()
+
+	return groups, err
+}
+
+func (c *Chain) compare(ctx context.Context, primaryName, shadowName string, groups []string, err error, shadow result) {
+	log := slog.With("primary", primaryName, "shadow", shadowName)
+	switch {
+	case err != nil && shadow.err != nil:
+		return
+	case err != nil || shadow.err != nil:
+		log.Warn("shadow group lookup outcome differs", "primaryError", errString(err), "shadowError", errString(shadow.err))
+	default:
+		missing := difference(groups, shadow.groups)
+		extra := difference(shadow.groups, groups)
+		if len(missing) == 0 && len(extra) == 0 {
+			return
+		}
+		log.Warn("shadow group lookup differs", "missing", missing, "extra", extra)
+	}
+	shadowDiscrepancies.Add(ctx, 1, metric.WithAttributes(attribute.String("provider", shadowName)))
+}
+
+// difference returns the elements of a not in b
+func difference(a, b []string) []string {
+	var diff []string
+	for _, s := range a {
+		if !slices.Contains(b, s) {
+			diff = append(diff, s)
+		}
+	}
+	return diff
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
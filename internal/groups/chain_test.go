@@ -0,0 +1,158 @@
+package groups_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/statisticsnorway/labid/internal/groups"
+	"go.opentelemetry.io/otel"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func Static(gs []string, err error) groups.NamedProvider {
+	return groups.NamedProvider{
+		Name: "static",
+		Provider: groups.ProviderFunc(func(context.Context, string) ([]string, error) {
+			return gs, err
+		}),
+	}
+}
+
+func TestChain(t *testing.T) {
+	unavailable := Static(nil, groups.ErrUnavailable)
+	notFound := Static(nil, groups.ErrUserNotFound)
+
+	for _, tc := range []struct {
+		name      string
+		mode      groups.Mode
+		providers []groups.NamedProvider
+		want      []string
+		wantErr   error
+	}{
+		{"fallback uses primary", groups.ModeFallback, []groups.NamedProvider{Static([]string{"a"}, nil), Static([]string{"b"}, nil)}, []string{"a"}, nil},
+		{"fallback on outage", groups.ModeFallback, []groups.NamedProvider{unavailable, Static([]string{"b"}, nil)}, []string{"b"}, nil},
+		{"fallback not on unknown user", groups.ModeFallback, []groups.NamedProvider{notFound, Static([]string{"b"}, nil)}, nil, groups.ErrUserNotFound},
+		{"fallback all failing", groups.ModeFallback, []groups.NamedProvider{unavailable, unavailable}, nil, groups.ErrUnavailable},
+		{"union", groups.ModeUnion, []groups.NamedProvider{Static([]string{"a", "b"}, nil), notFound, Static([]string{"b", "c"}, nil)}, []string{"a", "b", "c"}, nil},
+		{"union fails on outage", groups.ModeUnion, []groups.NamedProvider{Static([]string{"a"}, nil), unavailable}, nil, groups.ErrUnavailable},
+		{"union unknown everywhere", groups.ModeUnion, []groups.NamedProvider{notFound, notFound}, nil, groups.ErrUserNotFound},
+		{"shadow uses primary", groups.ModeShadow, []groups.NamedProvider{Static([]string{"a"}, nil), Static([]string{"b"}, nil)}, []string{"a"}, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			chain, err := groups.NewChain(tc.mode, tc.providers...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := chain.ListGroups(context.Background(), "kari@ssb.no")
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Fatalf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestChainUnknownMode(t *testing.T) {
+	if _, err := groups.NewChain("random", Static(nil, nil)); err == nil {
+		t.Fatal("expected error for unknown mode")
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for the shadow lookups logging in the
+// background.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// metricReader collects the metrics of the global meter provider, which can
+// only be set once for instruments created in init.
+var metricReader = sync.OnceValue(func() *sdkmetric.ManualReader {
+	reader := sdkmetric.NewManualReader()
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	return reader
+})
+
+// discrepancies returns the shadow discrepancies counted for provider.
+func discrepancies(t *testing.T, provider string) int64 {
+	var rm metricdata.ResourceMetrics
+	if err := metricReader().Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	var total int64
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			sum, ok := m.Data.(metricdata.Sum[int64])
+			if !ok || m.Name != "labid.groups.shadow.discrepancies" {
+				continue
+			}
+			for _, dp := range sum.DataPoints {
+				if v, _ := dp.Attributes.Value("provider"); v.AsString() == provider {
+					total += dp.Value
+				}
+			}
+		}
+	}
+	return total
+}
+
+func TestChainShadowRecordsDiscrepancies(t *testing.T) {
+	before := discrepancies(t, "shadow")
+	var logs syncBuffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	defer slog.SetDefault(defaultLogger)
+
+	shadow := Static([]string{"b", "c"}, nil)
+	shadow.Name = "shadow"
+	chain, err := groups.NewChain(groups.ModeShadow, Static([]string{"a", "b"}, nil), shadow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := chain.ListGroups(context.Background(), "kari@ssb.no")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a", "b"}; !slices.Equal(got, want) {
+		t.Fatalf("expected the primary groups %v, got %v", want, got)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for discrepancies(t, "shadow") != before+1 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected a shadow discrepancy to be counted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if l := logs.String(); !strings.Contains(l, "shadow group lookup differs") || !strings.Contains(l, "missing=[a]") || !strings.Contains(l, "extra=[c]") {
+		t.Fatalf("expected the discrepancy to be logged, got %q", l)
+	}
+}
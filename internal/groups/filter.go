@@ -0,0 +1,131 @@
+package groups
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Filter keeps groups starting with one of Prefixes, matching one of
+// Patterns, or listed in Groups. An empty filter keeps no groups.
+type Filter struct {
+	Prefixes []string `json:"prefixes"`
+	Patterns []string `json:"patterns"`
+	Groups   []string `json:"groups"`
+
+	patterns []*regexp.Regexp
+}
+
+func (f *Filter) compile() error {
+	f.patterns = nil
+	for _, p := range f.Patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return fmt.Errorf("compile group pattern: %w", err)
+		}
+		f.patterns = append(f.patterns, re)
+	}
+	return nil
+}
+
+func (f *Filter) keep(group string) bool {
+	if slices.Contains(f.Groups, group) {
+		return true
+	}
+	for _, p := range f.Prefixes {
+		if strings.HasPrefix(group, p) {
+			return true
+		}
+	}
+	for _, re := range f.patterns {
+		if re.MatchString(group) {
+			return true
+		}
+	}
+	return false
+}
+
+// AudienceFilter is the filter for tokens requested for Audience.
+type AudienceFilter struct {
+	Audience string `json:"audience"`
+	Filter
+}
+
+// Filters is the format of the group filter file, in YAML or JSON:
+//
+//	audiences:
+//	  - audience: https://storage.dapla.ssb.no
+//	    prefixes: [dapla-felles-]
+//	    patterns: ['^.+-data-admins$']
+//	    groups: [dapla-admins]
+//	default:
+//	  prefixes: [dapla-]
+//
+// Audiences without a filter get the default filter, or all groups if there
+// is no default.
+type Filters struct {
+	Audiences []AudienceFilter `json:"audiences"`
+	Default   *Filter          `json:"default"`
+}
+
+func ParseFilters(data []byte) (*Filters, error) {
+	var f Filters
+	if err := yaml.UnmarshalStrict(data, &f); err != nil {
+		return nil, fmt.Errorf("unmarshal group filters: %w", err)
+	}
+	for i := range f.Audiences {
+		if f.Audiences[i].Audience == "" {
+			return nil, errors.New("group filter without audience")
+		}
+		if err := f.Audiences[i].compile(); err != nil {
+			return nil, fmt.Errorf("audience %q: %w", f.Audiences[i].Audience, err)
+		}
+	}
+	if f.Default != nil {
+		if err := f.Default.compile(); err != nil {
+			return nil, fmt.Errorf("default: %w", err)
+		}
+	}
+	return &f, nil
+}
+
+func (f *Filters) forAudience(audience string) *Filter {
+	for i := range f.Audiences {
+		if f.Audiences[i].Audience == audience {
+			return &f.Audiences[i].Filter
+		}
+	}
+	return f.Default
+}
+
+// Apply returns the union of the groups kept by the filters of audiences,
+// using the default filter for audiences without one, or for no audiences.
+// All groups are returned if any audience is left unfiltered.
+func (f *Filters) Apply(audiences []string, groups []string) []string {
+	var filters []*Filter
+	for _, aud := range audiences {
+		filter := f.forAudience(aud)
+		if filter == nil {
+			return groups
+		}
+		filters = append(filters, filter)
+	}
+	if len(filters) == 0 {
+		if f.Default == nil {
+			return groups
+		}
+		filters = append(filters, f.Default)
+	}
+
+	kept := []string{}
+	for _, g := range groups {
+		if slices.ContainsFunc(filters, func(filter *Filter) bool { return filter.keep(g) }) {
+			kept = append(kept, g)
+		}
+	}
+	return kept
+}
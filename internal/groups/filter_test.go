@@ -0,0 +1,111 @@
+package groups_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v3/jwt"
+	"github.com/statisticsnorway/labid/internal/groups"
+	"github.com/statisticsnorway/labid/internal/identity"
+	"github.com/statisticsnorway/labid/internal/token"
+)
+
+const filters = `
+audiences:
+  - audience: storage
+    prefixes: [dapla-felles-]
+  - audience: admin
+    patterns: ['-admins$']
+    groups: [play-foeniks-developers]
+default:
+  groups: [dapla-admins]
+`
+
+var userGroups = []string{"dapla-felles-developers", "dapla-admins", "play-foeniks-developers", "play-foeniks-data-admins"}
+
+func TestFiltersApply(t *testing.T) {
+	f, err := groups.ParseFilters([]byte(filters))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		audience []string
+		want     []string
+	}{
+		{[]string{"storage"}, []string{"dapla-felles-developers"}},
+		{[]string{"admin"}, []string{"dapla-admins", "play-foeniks-developers", "play-foeniks-data-admins"}},
+		{[]string{"storage", "other"}, []string{"dapla-felles-developers", "dapla-admins"}},
+		{nil, []string{"dapla-admins"}},
+	} {
+		if got := f.Apply(tc.audience, userGroups); !slices.Equal(got, tc.want) {
+			t.Errorf("audience %v: expected %v, got %v", tc.audience, tc.want, got)
+		}
+	}
+
+	// Audiences without a filter see all groups if there is no default
+	f, err = groups.ParseFilters([]byte("audiences: [{audience: storage, prefixes: [dapla-felles-]}]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := f.Apply([]string{"storage", "other"}, userGroups); !slices.Equal(got, userGroups) {
+		t.Errorf("expected all groups, got %v", got)
+	}
+
+	if _, err := groups.ParseFilters([]byte("audiences: [{audience: a, patterns: ['(']}]")); err == nil {
+		t.Error("expected error for invalid pattern")
+	}
+}
+
+func TestAllGroupsPopulatorOverage(t *testing.T) {
+	provider := groups.ProviderFunc(func(context.Context, string) ([]string, error) {
+		return userGroups, nil
+	})
+	f, err := groups.ParseFilters([]byte(filters))
+	if err != nil {
+		t.Fatal(err)
+	}
+	populate := groups.AllGroupsPopulator(provider, groups.WithFilters(f), groups.WithOverage(2, "https://labid/groups"), groups.WithEmailClaim(false))
+
+	issue := func(audience ...string) jwt.Token {
+		builder := jwt.NewBuilder()
+		mc := token.MapperContext{
+			Identity: identity.Identity{Kind: identity.KindUser, Subject: "kari", Email: "kari@ssb.no"},
+			Audience: audience,
+		}
+		if err := populate(context.Background(), mc)(context.Background(), builder); err != nil {
+			t.Fatal(err)
+		}
+		tok, err := builder.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tok
+	}
+
+	var got []string
+	tok := issue("storage")
+	if err := tok.Get("dapla.groups", &got); err != nil || len(got) != 1 {
+		t.Fatalf("expected one group, got %v, %v", got, err)
+	}
+	if tok.Has("email") {
+		t.Fatal("expected no email claim without overage")
+	}
+
+	tok = issue("admin")
+	if tok.Has("dapla.groups") {
+		t.Fatal("expected groups to be left out")
+	}
+	var email string
+	if err := tok.Get("email", &email); err != nil || email != "kari@ssb.no" {
+		t.Fatalf("expected email claim for looking up the groups, got %q, %v", email, err)
+	}
+	var sources map[string]any
+	if err := tok.Get("_claim_sources", &sources); err != nil {
+		t.Fatal(err)
+	}
+	if source, _ := sources["groups"].(map[string]string); source["endpoint"] != "https://labid/groups" {
+		t.Fatalf("expected group lookup endpoint, got %v", sources)
+	}
+}
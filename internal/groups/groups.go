@@ -0,0 +1,133 @@
+package groups
+
+import (
+	"context"
+	"errors"
+
+	"github.com/lestrrat-go/jwx/v3/jwt"
+	"github.com/statisticsnorway/labid/internal/token"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+)
+
+var (
+	ErrUserNotFound = errors.New("user not found")
+	// ErrUnavailable is returned when the upstream could not be reached or
+	// failed, as opposed to giving a definite answer.
+	ErrUnavailable = errors.New("upstream unavailable")
+)
+
+// Provider looks up the groups of a user, identified by their principal
+// email.
+type Provider interface {
+	ListGroups(ctx context.Context, userPrincipalEmail string) ([]string, error)
+}
+
+type ProviderFunc func(ctx context.Context, userPrincipalEmail string) ([]string, error)
+
+func (f ProviderFunc) ListGroups(ctx context.Context, userPrincipalEmail string) ([]string, error) {
+	return f(ctx, userPrincipalEmail)
+}
+
+var overageTokens metric.Int64Counter
+
+func init() {
+	var err error
+	overageTokens, err = otel.Meter("github.com/statisticsnorway/labid/internal/groups").Int64Counter(
+		"labid.groups.overage",
+		metric.WithDescription("Number of tokens issued without groups, since the user had too many"),
+		metric.WithUnit("{token}"),
+	)
+	if err != nil {
+		otel.Handle(err)
+	}
+}
+
+type populator struct {
+	filters          *Filters
+	overageThreshold int
+	overageEndpoint  string
+	hierarchy        *Hierarchy
+	emailClaim       bool
+}
+
+type populatorOptFunc func(*populator)
+
+// WithFilters only includes the groups visible to the requested audiences.
+// Nil filters include all groups.
+func WithFilters(f *Filters) populatorOptFunc {
+	return func(p *populator) {
+		p.filters = f
+	}
+}
+
+// WithOverage leaves out the groups if there are more than threshold after
+// filtering, and points to endpoint for looking them up instead, with the
+// _claim_names and _claim_sources claims as in OpenID Connect aggregated
+// and distributed claims. A threshold of 0 disables overage.
+func WithOverage(threshold int, endpoint string) populatorOptFunc {
+	return func(p *populator) {
+		p.overageThreshold = threshold
+		p.overageEndpoint = endpoint
+	}
+}
+
+// WithHierarchy adds the teams of the user as the dapla.teams claim, and
+// their roles in each team as the dapla.team_roles claim. These are kept on
+// overage. A nil hierarchy adds neither.
+func WithHierarchy(h *Hierarchy) populatorOptFunc {
+	return func(p *populator) {
+		p.hierarchy = h
+	}
+}
+
+// WithEmailClaim sets whether the email of the user is added to all tokens,
+// so the groups endpoints can be used with them, which is the default. When
+// disabled, only tokens left without groups on overage get it.
+func WithEmailClaim(enabled bool) populatorOptFunc {
+	return func(p *populator) {
+		p.emailClaim = enabled
+	}
+}
+
+// AllGroupsPopulator adds the groups of the user as the dapla.groups claim.
+func AllGroupsPopulator(p Provider, opts ...populatorOptFunc) token.AllGroupsPopulator {
+	pop := &populator{emailClaim: true}
+	for _, opt := range opts {
+		opt(pop)
+	}
+
+	return func(_ context.Context, mc token.MapperContext) token.Mapper {
+		return func(ctx context.Context, builder *jwt.Builder) error {
+			groups, err := p.ListGroups(ctx, mc.Identity.Email)
+			if err != nil {
+				return err
+			}
+			if pop.filters != nil {
+				groups = pop.filters.Apply(mc.Audience, groups)
+			}
+			if pop.hierarchy != nil {
+				teams, roles := pop.hierarchy.Teams(groups)
+				builder.Claim(TeamsClaim, teams)
+				builder.Claim(TeamRolesClaim, roles)
+			}
+
+			// Lets the groups endpoints look up the groups of the token
+			if pop.emailClaim {
+				builder.Claim("email", mc.Identity.Email)
+			}
+			if pop.overageThreshold > 0 && len(groups) > pop.overageThreshold {
+				overageTokens.Add(ctx, 1)
+				builder.Claim("email", mc.Identity.Email)
+				builder.Claim("_claim_names", map[string]string{"dapla.groups": "groups"})
+				builder.Claim("_claim_sources", map[string]any{
+					"groups": map[string]string{"endpoint": pop.overageEndpoint},
+				})
+				return nil
+			}
+
+			builder.Claim("dapla.groups", groups)
+			return nil
+		}
+	}
+}
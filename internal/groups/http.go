@@ -0,0 +1,138 @@
+package groups
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/lestrrat-go/jwx/v3/jwt"
+)
+
+// TokenVerifier validates a LabID token.
+type TokenVerifier func(rawToken string) (jwt.Token, error)
+
+// API serves the groups of the bearer of a LabID token with the all_groups
+// scope, e.g. for tokens where the groups were left out due to overage.
+type API struct {
+	provider Provider
+	verify   TokenVerifier
+	filters  *Filters
+}
+
+// NewAPI creates the groups API. filters may be nil, otherwise the groups
+// are filtered by the audience of the token, the same as in the token.
+func NewAPI(p Provider, verify TokenVerifier, filters *Filters) *API {
+	return &API{
+		provider: p,
+		verify:   verify,
+		filters:  filters,
+	}
+}
+
+type ListResponse struct {
+	Groups []string `json:"groups"`
+}
+
+type CheckResponse struct {
+	Groups map[string]bool `json:"groups"`
+}
+
+// List responds with the groups of the bearer.
+func (a *API) List(w http.ResponseWriter, r *http.Request) {
+	groups, ok := a.groups(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, ListResponse{Groups: groups})
+}
+
+// Check responds with whether the bearer is a member of each of the group
+// query parameters, e.g. /groups/check?group=a&group=b.
+func (a *API) Check(w http.ResponseWriter, r *http.Request) {
+	checks := r.URL.Query()["group"]
+	if len(checks) == 0 {
+		writeError(w, http.StatusBadRequest, "invalid_request", "group query parameter is required")
+		return
+	}
+	groups, ok := a.groups(w, r)
+	if !ok {
+		return
+	}
+	res := CheckResponse{Groups: map[string]bool{}}
+	for _, g := range checks {
+		res.Groups[g] = slices.Contains(groups, g)
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// groups authenticates the request and looks up the groups of the bearer.
+// If it returns false, an error response has been written.
+func (a *API) groups(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	rawToken, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer`)
+		writeError(w, http.StatusUnauthorized, "invalid_request", "bearer token is required")
+		return nil, false
+	}
+	token, err := a.verify(rawToken)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeError(w, http.StatusUnauthorized, "invalid_token", err.Error())
+		return nil, false
+	}
+	var scope, email string
+	_ = token.Get("scope", &scope)
+	_ = token.Get("email", &email)
+	if !slices.Contains(strings.Split(scope, ","), "all_groups") {
+		w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="all_groups"`)
+		writeError(w, http.StatusForbidden, "insufficient_scope", "token must have the all_groups scope")
+		return nil, false
+	}
+	if email == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeError(w, http.StatusUnauthorized, "invalid_token", "token has no email claim to look up the user by")
+		return nil, false
+	}
+
+	groups, err := a.provider.ListGroups(r.Context(), email)
+	switch {
+	case errors.Is(err, ErrUserNotFound):
+		writeError(w, http.StatusNotFound, "user_not_found", fmt.Sprintf("no groups found for %q", email))
+		return nil, false
+	case errors.Is(err, ErrUnavailable), errors.Is(err, context.DeadlineExceeded):
+		slog.Warn("list groups", "error", err.Error())
+		writeError(w, http.StatusServiceUnavailable, "temporarily_unavailable", "group lookup failed")
+		return nil, false
+	case err != nil:
+		slog.Error("list groups", "error", err.Error())
+		writeError(w, http.StatusInternalServerError, "server_error", "group lookup failed")
+		return nil, false
+	}
+
+	if a.filters != nil {
+		audience, _ := token.Audience()
+		groups = a.filters.Apply(audience, groups)
+	}
+	if groups == nil {
+		groups = []string{}
+	}
+	return groups, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, description string) {
+	writeJSON(w, status, map[string]string{
+		"error":             code,
+		"error_description": description,
+	})
+}
@@ -0,0 +1,130 @@
+package groups_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v3/jwt"
+	"github.com/statisticsnorway/labid/internal/groups"
+	"github.com/statisticsnorway/labid/internal/identity"
+	"github.com/statisticsnorway/labid/internal/token"
+)
+
+func testAPI(t *testing.T, claims map[string]any) *groups.API {
+	t.Helper()
+	f, err := groups.ParseFilters([]byte(filters))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify := func(raw string) (jwt.Token, error) {
+		if raw != "valid" {
+			return nil, errors.New("invalid signature")
+		}
+		b := jwt.NewBuilder()
+		for k, v := range claims {
+			b.Claim(k, v)
+		}
+		return b.Build()
+	}
+	provider := groups.ProviderFunc(func(_ context.Context, email string) ([]string, error) {
+		if email != "kari@ssb.no" {
+			return nil, groups.ErrUserNotFound
+		}
+		return userGroups, nil
+	})
+	return groups.NewAPI(provider, verify, f)
+}
+
+func serve(handler http.HandlerFunc, target, rawToken string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	if rawToken != "" {
+		req.Header.Set("Authorization", "Bearer "+rawToken)
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestAPIList(t *testing.T) {
+	api := testAPI(t, map[string]any{"scope": "current_group,all_groups", "email": "kari@ssb.no", "aud": []string{"storage"}})
+
+	rec := serve(api.List, "/groups", "valid")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var res groups.ListResponse
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"dapla-felles-developers"}; !reflect.DeepEqual(res.Groups, want) {
+		t.Fatalf("expected %v, got %v", want, res.Groups)
+	}
+}
+
+func TestAPICheck(t *testing.T) {
+	api := testAPI(t, map[string]any{"scope": "all_groups", "email": "kari@ssb.no", "aud": []string{"admin"}})
+
+	rec := serve(api.Check, "/groups/check?group=dapla-admins&group=dapla-felles-developers", "valid")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var res groups.CheckResponse
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"dapla-admins": true, "dapla-felles-developers": false}
+	if !reflect.DeepEqual(res.Groups, want) {
+		t.Fatalf("expected %v, got %v", want, res.Groups)
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		claims   map[string]any
+		target   string
+		rawToken string
+		status   int
+	}{
+		"no token":        {target: "/groups", status: http.StatusUnauthorized},
+		"invalid token":   {target: "/groups", rawToken: "forged", status: http.StatusUnauthorized},
+		"missing scope":   {claims: map[string]any{"scope": "current_group", "email": "kari@ssb.no"}, target: "/groups", rawToken: "valid", status: http.StatusForbidden},
+		"missing email":   {claims: map[string]any{"scope": "all_groups"}, target: "/groups", rawToken: "valid", status: http.StatusUnauthorized},
+		"unknown user":    {claims: map[string]any{"scope": "all_groups", "email": "ola@ssb.no"}, target: "/groups", rawToken: "valid", status: http.StatusNotFound},
+		"no groups query": {claims: map[string]any{"scope": "all_groups", "email": "kari@ssb.no"}, target: "/groups/check", rawToken: "valid", status: http.StatusBadRequest},
+	} {
+		api := testAPI(t, tc.claims)
+		handler := api.List
+		if tc.target == "/groups/check" {
+			handler = api.Check
+		}
+		if rec := serve(handler, tc.target, tc.rawToken); rec.Code != tc.status {
+			t.Errorf("%s: expected %d, got %d: %s", name, tc.status, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestAPIWithDefaultPopulatorToken(t *testing.T) {
+	provider := groups.ProviderFunc(func(context.Context, string) ([]string, error) {
+		return userGroups, nil
+	})
+	builder := jwt.NewBuilder().Claim("scope", "all_groups")
+	mc := token.MapperContext{Identity: identity.Identity{Kind: identity.KindUser, Subject: "kari", Email: "kari@ssb.no"}}
+	if err := groups.AllGroupsPopulator(provider)(context.Background(), mc)(context.Background(), builder); err != nil {
+		t.Fatal(err)
+	}
+	tok, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := groups.NewAPI(provider, func(string) (jwt.Token, error) { return tok, nil }, nil)
+
+	rec := serve(api.List, "/groups", "valid")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+}
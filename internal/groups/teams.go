@@ -0,0 +1,185 @@
+package groups
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Claims set from the groups when a hierarchy is configured.
+const (
+	TeamsClaim     = "dapla.teams"
+	TeamRolesClaim = "dapla.team_roles"
+)
+
+// Role is a role of a team, which implies the roles in Implies, e.g. managers
+// implying developers.
+type Role struct {
+	Name    string   `json:"name"`
+	Implies []string `json:"implies"`
+}
+
+// TeamRole is the team and role of a group.
+type TeamRole struct {
+	Team string `json:"team"`
+	Role string `json:"role"`
+}
+
+// Hierarchy is the format of the team hierarchy file, in YAML or JSON:
+//
+//	roles:
+//	  - name: managers
+//	    implies: [data-admins]
+//	  - name: data-admins
+//	    implies: [developers]
+//	  - name: developers
+//	pattern: '^(?P<team>.+)-(?P<role>[a-z-]+)$'
+//	groups:
+//	  dapla-admins: {team: dapla, role: managers}
+//
+// Groups are named <team>-<role> for the roles, unless pattern is set, in
+// which case it must have the named groups team and role, and the role must
+// be one of the roles. Groups lists groups that do not follow the naming
+// convention. Other groups are not part of any team.
+type Hierarchy struct {
+	Roles   []Role              `json:"roles"`
+	Pattern string              `json:"pattern"`
+	Groups  map[string]TeamRole `json:"groups"`
+
+	pattern *regexp.Regexp
+	// implied is the role itself and all roles it implies, transitively
+	implied map[string][]string
+}
+
+func ParseHierarchy(data []byte) (*Hierarchy, error) {
+	var h Hierarchy
+	if err := yaml.UnmarshalStrict(data, &h); err != nil {
+		return nil, fmt.Errorf("unmarshal team hierarchy: %w", err)
+	}
+	if err := h.compile(); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+func (h *Hierarchy) compile() error {
+	if len(h.Roles) == 0 {
+		return errors.New("team hierarchy without roles")
+	}
+	implies := map[string][]string{}
+	for _, r := range h.Roles {
+		if r.Name == "" {
+			return errors.New("role without name")
+		}
+		if _, ok := implies[r.Name]; ok {
+			return fmt.Errorf("role %q is defined more than once", r.Name)
+		}
+		implies[r.Name] = r.Implies
+	}
+	for _, r := range h.Roles {
+		for _, i := range r.Implies {
+			if _, ok := implies[i]; !ok {
+				return fmt.Errorf("role %q implies unknown role %q", r.Name, i)
+			}
+		}
+	}
+
+	h.implied = map[string][]string{}
+	for _, r := range h.Roles {
+		roles, err := expand(implies, r.Name, nil)
+		if err != nil {
+			return err
+		}
+		slices.Sort(roles)
+		h.implied[r.Name] = slices.Compact(roles)
+	}
+
+	if h.Pattern != "" {
+		re, err := regexp.Compile(h.Pattern)
+		if err != nil {
+			return fmt.Errorf("compile team pattern: %w", err)
+		}
+		if re.SubexpIndex("team") < 0 || re.SubexpIndex("role") < 0 {
+			return errors.New("team pattern must have the named groups team and role")
+		}
+		h.pattern = re
+	}
+	for g, tr := range h.Groups {
+		if tr.Team == "" {
+			return fmt.Errorf("group %q without team", g)
+		}
+		if _, ok := implies[tr.Role]; !ok {
+			return fmt.Errorf("group %q has unknown role %q", g, tr.Role)
+		}
+	}
+	return nil
+}
+
+// expand returns role and the roles it implies, failing on cycles.
+func expand(implies map[string][]string, role string, path []string) ([]string, error) {
+	if slices.Contains(path, role) {
+		return nil, fmt.Errorf("roles imply each other: %s", strings.Join(append(path, role), " -> "))
+	}
+	roles := []string{role}
+	for _, i := range implies[role] {
+		implied, err := expand(implies, i, append(path, role))
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, implied...)
+	}
+	return roles, nil
+}
+
+// TeamRole returns the team and role of group, or false if it is not part of
+// a team.
+func (h *Hierarchy) TeamRole(group string) (TeamRole, bool) {
+	if tr, ok := h.Groups[group]; ok {
+		return tr, true
+	}
+	if h.pattern != nil {
+		m := h.pattern.FindStringSubmatch(group)
+		if m == nil {
+			return TeamRole{}, false
+		}
+		tr := TeamRole{Team: m[h.pattern.SubexpIndex("team")], Role: m[h.pattern.SubexpIndex("role")]}
+		if _, ok := h.implied[tr.Role]; !ok || tr.Team == "" {
+			return TeamRole{}, false
+		}
+		return tr, true
+	}
+	// The longest matching role wins, so data-admins is not read as admins
+	roles := slices.SortedFunc(maps.Keys(h.implied), func(a, b string) int {
+		return cmp.Compare(len(b), len(a))
+	})
+	for _, role := range roles {
+		if team, ok := strings.CutSuffix(group, "-"+role); ok && team != "" {
+			return TeamRole{Team: team, Role: role}, true
+		}
+	}
+	return TeamRole{}, false
+}
+
+// Teams returns the sorted teams of groups, and the sorted roles in each
+// team including implied roles.
+func (h *Hierarchy) Teams(groups []string) ([]string, map[string][]string) {
+	roles := map[string][]string{}
+	for _, g := range groups {
+		tr, ok := h.TeamRole(g)
+		if !ok {
+			continue
+		}
+		roles[tr.Team] = append(roles[tr.Team], h.implied[tr.Role]...)
+	}
+	for team, r := range roles {
+		slices.Sort(r)
+		roles[team] = slices.Compact(r)
+	}
+	return slices.Sorted(maps.Keys(roles)), roles
+}
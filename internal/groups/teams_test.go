@@ -0,0 +1,79 @@
+package groups_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/statisticsnorway/labid/internal/groups"
+)
+
+const hierarchy = `
+roles:
+  - name: managers
+    implies: [data-admins]
+  - name: data-admins
+    implies: [developers]
+  - name: developers
+  - name: admins
+groups:
+  dapla-admins: {team: dapla, role: managers}
+`
+
+func TestHierarchyTeams(t *testing.T) {
+	h, err := groups.ParseHierarchy([]byte(hierarchy))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	teams, roles := h.Teams([]string{
+		"dapla-felles-managers",
+		"play-foeniks-data-admins",
+		"play-foeniks-developers",
+		"dapla-admins",
+		"all-users",
+	})
+	if want := []string{"dapla", "dapla-felles", "play-foeniks"}; !reflect.DeepEqual(teams, want) {
+		t.Fatalf("expected teams %v, got %v", want, teams)
+	}
+	want := map[string][]string{
+		"dapla":        {"data-admins", "developers", "managers"},
+		"dapla-felles": {"data-admins", "developers", "managers"},
+		"play-foeniks": {"data-admins", "developers"},
+	}
+	if !reflect.DeepEqual(roles, want) {
+		t.Fatalf("expected roles %v, got %v", want, roles)
+	}
+}
+
+func TestHierarchyPattern(t *testing.T) {
+	h, err := groups.ParseHierarchy([]byte(`
+roles: [{name: developers}]
+pattern: '^team-(?P<team>[a-z]+)-(?P<role>[a-z]+)$'
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for group, want := range map[string]bool{
+		"team-felles-developers":  true,
+		"team-felles-consumers":   false,
+		"dapla-felles-developers": false,
+	} {
+		if _, ok := h.TeamRole(group); ok != want {
+			t.Errorf("%s: expected %t, got %t", group, want, ok)
+		}
+	}
+}
+
+func TestParseHierarchyErrors(t *testing.T) {
+	for name, data := range map[string]string{
+		"no roles":       `roles: []`,
+		"unknown role":   `roles: [{name: managers, implies: [developers]}]`,
+		"cycle":          `roles: [{name: a, implies: [b]}, {name: b, implies: [a]}]`,
+		"pattern groups": `{roles: [{name: a}], pattern: '^(.+)-(.+)$'}`,
+		"unknown field":  `{roles: [{name: a}], teams: []}`,
+	} {
+		if _, err := groups.ParseHierarchy([]byte(data)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
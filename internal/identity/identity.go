@@ -0,0 +1,163 @@
+package identity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+type Kind string
+
+const (
+	KindUser    Kind = "user"
+	KindService Kind = "service"
+)
+
+var ErrUnmappedNamespace = errors.New("namespace is not mapped to an identity")
+
+// Identity is who a token is issued to.
+type Identity struct {
+	Kind    Kind
+	Subject string
+	// Email is the principal email of a user, used to look up their groups.
+	// It is empty for services.
+	Email string
+}
+
+// Resolver finds the identity of a service account in a namespace. It
+// returns an error wrapping ErrUnmappedNamespace if the namespace belongs to
+// neither a user nor a service.
+type Resolver interface {
+	Resolve(ctx context.Context, namespace, serviceAccount string) (Identity, error)
+}
+
+type ResolverFunc func(ctx context.Context, namespace, serviceAccount string) (Identity, error)
+
+func (f ResolverFunc) Resolve(ctx context.Context, namespace, serviceAccount string) (Identity, error) {
+	return f(ctx, namespace, serviceAccount)
+}
+
+// Rule maps namespaces matching a pattern to an identity. The templates are
+// executed with the named capture groups of the pattern, and namespace and
+// serviceAccount, e.g. {{.user}}@ssb.no.
+type Rule struct {
+	kind    Kind
+	pattern *regexp.Regexp
+	subject *template.Template
+	email   *template.Template
+}
+
+// UserRule maps namespaces matching pattern to the user given by the
+// username and email templates.
+func UserRule(pattern, usernameTemplate, emailTemplate string) (Rule, error) {
+	return newRule(KindUser, pattern, usernameTemplate, emailTemplate)
+}
+
+// ServiceRule maps namespaces matching pattern to a service, with the
+// subject given by subjectTemplate. Services cannot have groups looked up.
+func ServiceRule(pattern, subjectTemplate string) (Rule, error) {
+	return newRule(KindService, pattern, subjectTemplate, "")
+}
+
+func newRule(kind Kind, pattern, subjectTemplate, emailTemplate string) (Rule, error) {
+	r := Rule{kind: kind}
+	var err error
+	if r.pattern, err = regexp.Compile(pattern); err != nil {
+		return r, fmt.Errorf("compile %s namespace pattern: %w", kind, err)
+	}
+	if r.subject, err = r.parse("subject", subjectTemplate); err != nil {
+		return r, err
+	}
+	if emailTemplate != "" {
+		if r.email, err = r.parse("email", emailTemplate); err != nil {
+			return r, err
+		}
+	}
+	return r, nil
+}
+
+func (r Rule) parse(name, text string) (*template.Template, error) {
+	t, err := parseTemplate(name, text, r.data(make([]string, r.pattern.NumSubexp()+1), "", ""))
+	if err != nil {
+		return nil, fmt.Errorf("%s %w", r.kind, err)
+	}
+	return t, nil
+}
+
+// parseTemplate parses a template, and checks that it only refers to the
+// keys of sample.
+func parseTemplate(name, text string, sample map[string]string) (*template.Template, error) {
+	t, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s template: %w", name, err)
+	}
+	if _, err := execute(t, sample); err != nil {
+		return nil, fmt.Errorf("%s template: %w", name, err)
+	}
+	return t, nil
+}
+
+func (r Rule) data(match []string, namespace, serviceAccount string) map[string]string {
+	data := map[string]string{
+		"namespace":      namespace,
+		"serviceAccount": serviceAccount,
+	}
+	for i, n := range r.pattern.SubexpNames() {
+		if n != "" && i < len(match) {
+			data[n] = match[i]
+		}
+	}
+	return data
+}
+
+func execute(t *template.Template, data map[string]string) (string, error) {
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// Mapping resolves identities with the first rule matching the namespace.
+type Mapping []Rule
+
+func (m Mapping) Resolve(_ context.Context, namespace, serviceAccount string) (Identity, error) {
+	for _, r := range m {
+		match := r.pattern.FindStringSubmatch(namespace)
+		if match == nil {
+			continue
+		}
+		data := r.data(match, namespace, serviceAccount)
+		id := Identity{Kind: r.kind}
+		var err error
+		if id.Subject, err = execute(r.subject, data); err != nil {
+			return id, fmt.Errorf("execute subject template for %q: %w", namespace, err)
+		}
+		if r.email != nil {
+			if id.Email, err = execute(r.email, data); err != nil {
+				return id, fmt.Errorf("execute email template for %q: %w", namespace, err)
+			}
+		}
+		if id.Subject == "" {
+			return id, fmt.Errorf("empty subject for %q: %w", namespace, ErrUnmappedNamespace)
+		}
+		return id, nil
+	}
+	return Identity{}, fmt.Errorf("%q: %w", namespace, ErrUnmappedNamespace)
+}
+
+// Default maps Dapla Lab user namespaces, user-ssb-<username>, to
+// <username>@ssb.no.
+var Default = Mapping{
+	must(UserRule(`^user-ssb-(?P<user>.+)$`, "{{.user}}", "{{.user}}@ssb.no")),
+}
+
+func must(r Rule, err error) Rule {
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
@@ -0,0 +1,65 @@
+package identity_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/statisticsnorway/labid/internal/identity"
+)
+
+func TestMappingResolve(t *testing.T) {
+	user, err := identity.UserRule(`^user-(?P<org>[a-z]+)-(?P<user>.+)$`, "{{.user}}", "{{.user}}@{{.org}}.no")
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := identity.ServiceRule(`^team-(?P<team>.+)$`, "team:{{.team}}:{{.serviceAccount}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapping := identity.Mapping{user, service}
+
+	for _, tc := range []struct {
+		namespace string
+		want      identity.Identity
+	}{
+		{"user-ssb-kari", identity.Identity{Kind: identity.KindUser, Subject: "kari", Email: "kari@ssb.no"}},
+		{"user-fhi-ola", identity.Identity{Kind: identity.KindUser, Subject: "ola", Email: "ola@fhi.no"}},
+		{"team-dapla-felles", identity.Identity{Kind: identity.KindService, Subject: "team:dapla-felles:default"}},
+	} {
+		got, err := mapping.Resolve(context.Background(), tc.namespace, "default")
+		if err != nil {
+			t.Fatalf("%s: %v", tc.namespace, err)
+		}
+		if got != tc.want {
+			t.Errorf("%s: expected %+v, got %+v", tc.namespace, tc.want, got)
+		}
+	}
+
+	if _, err := mapping.Resolve(context.Background(), "kube-system", "default"); !errors.Is(err, identity.ErrUnmappedNamespace) {
+		t.Fatalf("expected ErrUnmappedNamespace, got %v", err)
+	}
+}
+
+func TestDefaultMapping(t *testing.T) {
+	got, err := identity.Default.Resolve(context.Background(), "user-ssb-kari", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (identity.Identity{Kind: identity.KindUser, Subject: "kari", Email: "kari@ssb.no"}); got != want {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+}
+
+func TestRuleValidatesTemplates(t *testing.T) {
+	for name, tc := range map[string][3]string{
+		"invalid pattern":       {`^user-(`, "{{.user}}", ""},
+		"invalid template":      {`^user-(?P<user>.+)$`, "{{.user", ""},
+		"unknown capture group": {`^user-(?P<user>.+)$`, "{{.username}}", ""},
+		"unknown in email":      {`^user-(?P<user>.+)$`, "{{.user}}", "{{.name}}@ssb.no"},
+	} {
+		if _, err := identity.UserRule(tc[0], tc[1], tc[2]); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package identity
+
+import (
+	"context"
+	"fmt"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+type NamespaceGetter func(ctx context.Context, name string) (*corev1.Namespace, error)
+
+// NamespaceOwner resolves users from the owner recorded by the platform in
+// an annotation or label on the Namespace, e.g. onyxia.sh/owner. Namespaces
+// without an owner are resolved by next.
+type NamespaceOwner struct {
+	getNamespace NamespaceGetter
+	key          string
+	subject      *template.Template
+	email        *template.Template
+	next         Resolver
+}
+
+// NewNamespaceOwner creates a resolver reading the owner from the annotation
+// or label key. The templates are executed with owner, namespace and
+// serviceAccount, e.g. {{.owner}}@ssb.no.
+func NewNamespaceOwner(getNamespace NamespaceGetter, key, usernameTemplate, emailTemplate string, next Resolver) (*NamespaceOwner, error) {
+	sample := map[string]string{"owner": "", "namespace": "", "serviceAccount": ""}
+	subject, err := parseTemplate("subject", usernameTemplate, sample)
+	if err != nil {
+		return nil, fmt.Errorf("owner %w", err)
+	}
+	email, err := parseTemplate("email", emailTemplate, sample)
+	if err != nil {
+		return nil, fmt.Errorf("owner %w", err)
+	}
+	return &NamespaceOwner{
+		getNamespace: getNamespace,
+		key:          key,
+		subject:      subject,
+		email:        email,
+		next:         next,
+	}, nil
+}
+
+func (o *NamespaceOwner) Resolve(ctx context.Context, namespace, serviceAccount string) (Identity, error) {
+	ns, err := o.getNamespace(ctx, namespace)
+	if err != nil {
+		return Identity{}, fmt.Errorf("get namespace %q: %w", namespace, err)
+	}
+	owner, ok := ns.Annotations[o.key]
+	if !ok {
+		owner, ok = ns.Labels[o.key]
+	}
+	if !ok || owner == "" {
+		return o.next.Resolve(ctx, namespace, serviceAccount)
+	}
+
+	data := map[string]string{
+		"owner":          owner,
+		"namespace":      namespace,
+		"serviceAccount": serviceAccount,
+	}
+	id := Identity{Kind: KindUser}
+	if id.Subject, err = execute(o.subject, data); err != nil {
+		return id, fmt.Errorf("execute owner subject template for %q: %w", namespace, err)
+	}
+	if id.Email, err = execute(o.email, data); err != nil {
+		return id, fmt.Errorf("execute owner email template for %q: %w", namespace, err)
+	}
+	return id, nil
+}
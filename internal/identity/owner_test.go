@@ -0,0 +1,54 @@
+package identity_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/statisticsnorway/labid/internal/identity"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNamespaceOwner(t *testing.T) {
+	namespaces := map[string]*corev1.Namespace{
+		"user-ssb-kari": {ObjectMeta: metav1.ObjectMeta{
+			Name:        "user-ssb-kari",
+			Annotations: map[string]string{"onyxia.sh/owner": "kari.nordmann"},
+		}},
+		"project-felles": {ObjectMeta: metav1.ObjectMeta{
+			Name:   "project-felles",
+			Labels: map[string]string{"onyxia.sh/owner": "ola"},
+		}},
+		"user-ssb-ola": {ObjectMeta: metav1.ObjectMeta{Name: "user-ssb-ola"}},
+	}
+	getNamespace := func(_ context.Context, name string) (*corev1.Namespace, error) {
+		return namespaces[name], nil
+	}
+
+	owner, err := identity.NewNamespaceOwner(getNamespace, "onyxia.sh/owner", "{{.owner}}", "{{.owner}}@ssb.no", identity.Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		namespace string
+		want      identity.Identity
+	}{
+		{"user-ssb-kari", identity.Identity{Kind: identity.KindUser, Subject: "kari.nordmann", Email: "kari.nordmann@ssb.no"}},
+		{"project-felles", identity.Identity{Kind: identity.KindUser, Subject: "ola", Email: "ola@ssb.no"}},
+		// Namespaces without an owner fall back to the next resolver
+		{"user-ssb-ola", identity.Identity{Kind: identity.KindUser, Subject: "ola", Email: "ola@ssb.no"}},
+	} {
+		got, err := owner.Resolve(context.Background(), tc.namespace, "default")
+		if err != nil {
+			t.Fatalf("%s: %v", tc.namespace, err)
+		}
+		if got != tc.want {
+			t.Errorf("%s: expected %+v, got %+v", tc.namespace, tc.want, got)
+		}
+	}
+
+	if _, err := identity.NewNamespaceOwner(getNamespace, "onyxia.sh/owner", "{{.user}}", "{{.owner}}@ssb.no", identity.Default); err == nil {
+		t.Fatal("expected error for unknown template key")
+	}
+}
@@ -0,0 +1,71 @@
+package kubecache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+)
+
+type Namespaces struct {
+	client kubernetes.Interface
+	lister corev1listers.NamespaceLister
+}
+
+// NewNamespaces starts a shared informer for namespaces and waits for its
+// initial sync. The informer stops when ctx is cancelled.
+func NewNamespaces(ctx context.Context, client kubernetes.Interface, resync time.Duration) (*Namespaces, error) {
+	n := &Namespaces{client: client}
+
+	factory := informers.NewSharedInformerFactory(client, resync)
+	informer := factory.Core().V1().Namespaces()
+	if err := informer.Informer().SetTransform(transformNamespace); err != nil {
+		return nil, fmt.Errorf("set namespace transform: %w", err)
+	}
+	n.lister = informer.Lister()
+
+	factory.Start(ctx.Done())
+	for typ, synced := range factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			return nil, fmt.Errorf("sync informer cache for %s", typ)
+		}
+	}
+
+	return n, nil
+}
+
+// transformNamespace drops everything but the metadata LabID uses.
+func transformNamespace(obj any) (any, error) {
+	ns, ok := obj.(*corev1.Namespace)
+	if !ok {
+		return obj, nil
+	}
+	return &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            ns.Name,
+			UID:             ns.UID,
+			ResourceVersion: ns.ResourceVersion,
+			Labels:          ns.Labels,
+			Annotations:     ns.Annotations,
+		},
+	}, nil
+}
+
+// Get returns the namespace from the cache, falling back to the Kubernetes
+// API if it is not yet in the cache.
+func (n *Namespaces) Get(ctx context.Context, name string) (*corev1.Namespace, error) {
+	ns, err := n.lister.Get(name)
+	if err == nil {
+		return ns, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("get namespace from cache: %w", err)
+	}
+	return n.client.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+}
@@ -0,0 +1,36 @@
+package kubecache_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/statisticsnorway/labid/internal/kubecache"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNamespacesFromCache(t *testing.T) {
+	client := fake.NewClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "user-ssb-kari",
+		Annotations: map[string]string{"onyxia.sh/owner": "kari"},
+	}})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	namespaces, err := kubecache.NewNamespaces(ctx, client, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ns, err := namespaces.Get(ctx, "user-ssb-kari")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ns.Annotations["onyxia.sh/owner"] != "kari" {
+		t.Fatalf("expected annotations to be cached, got %v", ns.Annotations)
+	}
+	if _, err := namespaces.Get(ctx, "missing"); err == nil {
+		t.Fatal("expected not found error")
+	}
+}
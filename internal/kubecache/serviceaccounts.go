@@ -0,0 +1,123 @@
+package kubecache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+)
+
+type ServiceAccounts struct {
+	client          kubernetes.Interface
+	lister          corev1listers.ServiceAccountLister
+	labelSelector   string
+	namespacePrefix string
+	resync          time.Duration
+}
+
+type optFunc func(*ServiceAccounts)
+
+// WithLabelSelector only caches service accounts matching selector.
+func WithLabelSelector(selector string) optFunc {
+	return func(s *ServiceAccounts) {
+		s.labelSelector = selector
+	}
+}
+
+// WithNamespacePrefix only keeps the metadata of service accounts in
+// namespaces starting with prefix. Kubernetes cannot filter watches on a
+// namespace prefix, so other service accounts are still watched, but are
+// stripped down to their name and always looked up directly.
+func WithNamespacePrefix(prefix string) optFunc {
+	return func(s *ServiceAccounts) {
+		s.namespacePrefix = prefix
+	}
+}
+
+func WithResync(resync time.Duration) optFunc {
+	return func(s *ServiceAccounts) {
+		s.resync = resync
+	}
+}
+
+// NewServiceAccounts starts a shared informer for service accounts and waits
+// for its initial sync. The informer stops when ctx is cancelled.
+func NewServiceAccounts(ctx context.Context, client kubernetes.Interface, opts ...optFunc) (*ServiceAccounts, error) {
+	s := &ServiceAccounts{
+		client: client,
+		resync: 10 * time.Minute,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(
+		client,
+		s.resync,
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.LabelSelector = s.labelSelector
+		}),
+	)
+	informer := factory.Core().V1().ServiceAccounts()
+	if err := informer.Informer().SetTransform(s.transform); err != nil {
+		return nil, fmt.Errorf("set service account transform: %w", err)
+	}
+	s.lister = informer.Lister()
+
+	factory.Start(ctx.Done())
+	for typ, synced := range factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			return nil, fmt.Errorf("sync informer cache for %s", typ)
+		}
+	}
+
+	return s, nil
+}
+
+// transform drops everything but the metadata LabID uses, to keep the
+// memory footprint of the cache small.
+func (s *ServiceAccounts) transform(obj any) (any, error) {
+	sa, ok := obj.(*corev1.ServiceAccount)
+	if !ok {
+		return obj, nil
+	}
+	stripped := &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            sa.Name,
+			Namespace:       sa.Namespace,
+			UID:             sa.UID,
+			ResourceVersion: sa.ResourceVersion,
+		},
+	}
+	if s.inScope(sa.Namespace) {
+		stripped.Labels = sa.Labels
+		stripped.Annotations = sa.Annotations
+	}
+	return stripped, nil
+}
+
+func (s *ServiceAccounts) inScope(namespace string) bool {
+	return strings.HasPrefix(namespace, s.namespacePrefix)
+}
+
+// Get returns the service account from the cache, falling back to the
+// Kubernetes API if it is out of scope or not yet in the cache.
+func (s *ServiceAccounts) Get(ctx context.Context, name, namespace string) (*corev1.ServiceAccount, error) {
+	if s.inScope(namespace) {
+		sa, err := s.lister.ServiceAccounts(namespace).Get(name)
+		if err == nil {
+			return sa, nil
+		}
+		if !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("get service account from cache: %w", err)
+		}
+	}
+	return s.client.CoreV1().ServiceAccounts(namespace).Get(ctx, name, metav1.GetOptions{})
+}
@@ -0,0 +1,86 @@
+package kubecache_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/statisticsnorway/labid/internal/kubecache"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func ServiceAccount(name, namespace string, annotations map[string]string) *corev1.ServiceAccount {
+	return &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Annotations: annotations,
+		},
+	}
+}
+
+// FakeClient returns a fake clientset and a counter of direct GETs.
+func FakeClient(objects ...runtime.Object) (*fake.Clientset, *atomic.Int32) {
+	client := fake.NewClientset(objects...)
+	var gets atomic.Int32
+	client.PrependReactor("get", "serviceaccounts", func(k8stesting.Action) (bool, runtime.Object, error) {
+		gets.Add(1)
+		return false, nil, nil
+	})
+	return client, &gets
+}
+
+func TestServiceAccountsFromCache(t *testing.T) {
+	client, gets := FakeClient(ServiceAccount("sa", "user-ssb-kari", map[string]string{"a": "b"}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sas, err := kubecache.NewServiceAccounts(ctx, client, kubecache.WithNamespacePrefix("user-ssb-"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sa, err := sas.Get(ctx, "sa", "user-ssb-kari")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sa.Annotations["a"] != "b" {
+		t.Fatalf("expected annotations to be cached, got %v", sa.Annotations)
+	}
+	if n := gets.Load(); n != 0 {
+		t.Fatalf("expected no direct gets, got %d", n)
+	}
+}
+
+func TestServiceAccountsFallback(t *testing.T) {
+	client, gets := FakeClient(ServiceAccount("sa", "other", map[string]string{"a": "b"}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sas, err := kubecache.NewServiceAccounts(ctx, client, kubecache.WithNamespacePrefix("user-ssb-"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Out of scope namespaces are always looked up directly
+	sa, err := sas.Get(ctx, "sa", "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sa.Annotations["a"] != "b" {
+		t.Fatalf("expected full service account, got annotations %v", sa.Annotations)
+	}
+
+	// Service accounts missing from the cache are looked up directly
+	if _, err := sas.Get(ctx, "missing", "user-ssb-kari"); err == nil {
+		t.Fatal("expected not found error")
+	}
+
+	if n := gets.Load(); n != 2 {
+		t.Fatalf("expected 2 direct gets, got %d", n)
+	}
+}
@@ -0,0 +1,202 @@
+package ldapgroups
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/statisticsnorway/labid/internal/groups"
+)
+
+// Matching rule OID of LDAP_MATCHING_RULE_IN_CHAIN, which makes Active
+// Directory resolve nested group memberships.
+const matchingRuleInChain = "1.2.840.113556.1.4.1941"
+
+type Client struct {
+	url          string
+	bindDN       string
+	bindPassword string
+	userBaseDN   string
+	userFilter   string
+	groupBaseDN  string
+	nested       bool
+	groupPattern *regexp.Regexp
+	startTLS     bool
+	tlsConfig    *tls.Config
+	timeout      time.Duration
+}
+
+type optFunc func(*Client)
+
+// WithUserFilter sets the filter used to find the user, where %s is
+// replaced by the escaped user principal name.
+func WithUserFilter(filter string) optFunc {
+	return func(c *Client) {
+		c.userFilter = filter
+	}
+}
+
+// WithNestedGroups resolves nested group memberships by searching groupBaseDN
+// with the matching-rule-in-chain filter, instead of reading memberOf. An
+// empty groupBaseDN keeps using memberOf.
+func WithNestedGroups(groupBaseDN string) optFunc {
+	return func(c *Client) {
+		c.nested = groupBaseDN != ""
+		c.groupBaseDN = groupBaseDN
+	}
+}
+
+// WithGroupPattern maps group DNs to group names with pattern. The first
+// capture group is used as name, and groups not matching are left out. A nil
+// pattern keeps the default, which uses the CN of every group.
+func WithGroupPattern(pattern *regexp.Regexp) optFunc {
+	return func(c *Client) {
+		if pattern != nil {
+			c.groupPattern = pattern
+		}
+	}
+}
+
+// WithStartTLS upgrades ldap:// connections with StartTLS if tlsConfig is
+// not nil.
+func WithStartTLS(tlsConfig *tls.Config) optFunc {
+	return func(c *Client) {
+		c.startTLS = tlsConfig != nil
+		c.tlsConfig = tlsConfig
+	}
+}
+
+func WithTimeout(timeout time.Duration) optFunc {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// NewClient creates a group provider for an LDAP directory, e.g. Active
+// Directory, at url (ldap:// or ldaps://). It binds as bindDN to find users
+// under userBaseDN.
+func NewClient(url, bindDN, bindPassword, userBaseDN string, opts ...optFunc) *Client {
+	c := &Client{
+		url:          url,
+		bindDN:       bindDN,
+		bindPassword: bindPassword,
+		userBaseDN:   userBaseDN,
+		userFilter:   "(userPrincipalName=%s)",
+		groupPattern: regexp.MustCompile(`^(?i:cn)=([^,]+)`),
+		timeout:      10 * time.Second,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// connect dials and binds to the directory. The connection is closed when
+// ctx is done, aborting outstanding requests, until the returned close
+// function is called.
+func (c *Client) connect(ctx context.Context) (*ldap.Conn, func(), error) {
+	timeout := c.timeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = min(timeout, time.Until(deadline))
+	}
+	conn, err := ldap.DialURL(c.url, ldap.DialWithDialer(&net.Dialer{Timeout: timeout}))
+	if err != nil {
+		return nil, nil, fmt.Errorf("dial ldap: %w: %w", groups.ErrUnavailable, err)
+	}
+	conn.SetTimeout(timeout)
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	closeConn := func() {
+		stop()
+		conn.Close()
+	}
+
+	if c.startTLS {
+		if err := conn.StartTLS(c.tlsConfig); err != nil {
+			closeConn()
+			return nil, nil, c.requestError(ctx, "start tls", err)
+		}
+	}
+	if err := conn.Bind(c.bindDN, c.bindPassword); err != nil {
+		closeConn()
+		return nil, nil, c.requestError(ctx, fmt.Sprintf("bind as %q", c.bindDN), err)
+	}
+	return conn, closeConn, nil
+}
+
+func (c *Client) ListGroups(ctx context.Context, userPrincipalEmail string) ([]string, error) {
+	conn, closeConn, err := c.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer closeConn()
+
+	users, err := conn.Search(ldap.NewSearchRequest(
+		c.userBaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		fmt.Sprintf(c.userFilter, ldap.EscapeFilter(userPrincipalEmail)),
+		[]string{"memberOf"},
+		nil,
+	))
+	if err != nil {
+		return nil, c.requestError(ctx, fmt.Sprintf("search user %q", userPrincipalEmail), err)
+	}
+	switch len(users.Entries) {
+	case 0:
+		return nil, fmt.Errorf("ldap could not find user %q: %w", userPrincipalEmail, groups.ErrUserNotFound)
+	case 1:
+	default:
+		return nil, fmt.Errorf("ldap found %d users matching %q", len(users.Entries), userPrincipalEmail)
+	}
+	user := users.Entries[0]
+
+	groupDNs := user.GetAttributeValues("memberOf")
+	if c.nested {
+		groupEntries, err := conn.SearchWithPaging(ldap.NewSearchRequest(
+			c.groupBaseDN,
+			ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+			fmt.Sprintf("(member:%s:=%s)", matchingRuleInChain, ldap.EscapeFilter(user.DN)),
+			[]string{"dn"},
+			nil,
+		), 500)
+		if err != nil {
+			return nil, c.requestError(ctx, fmt.Sprintf("search nested groups of %q", user.DN), err)
+		}
+		groupDNs = nil
+		for _, g := range groupEntries.Entries {
+			groupDNs = append(groupDNs, g.DN)
+		}
+	}
+
+	return c.groupNames(groupDNs), nil
+}
+
+func (c *Client) groupNames(groupDNs []string) []string {
+	var names []string
+	for _, dn := range groupDNs {
+		if m := c.groupPattern.FindStringSubmatch(dn); len(m) > 1 {
+			names = append(names, m[1])
+		}
+	}
+	return names
+}
+
+// requestError wraps errors of requests to the directory, in
+// groups.ErrUnavailable if the directory could not be reached or is busy.
+func (c *Client) requestError(ctx context.Context, msg string, err error) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("%s: %w", msg, ctx.Err())
+	}
+	if ldap.IsErrorAnyOf(err, ldap.ErrorNetwork, ldap.LDAPResultTimeLimitExceeded, ldap.LDAPResultBusy, ldap.LDAPResultUnavailable) || errors.Is(err, net.ErrClosed) {
+		return fmt.Errorf("%s: %w: %w", msg, groups.ErrUnavailable, err)
+	}
+	return fmt.Errorf("%s: %w", msg, err)
+}
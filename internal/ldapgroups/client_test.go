@@ -0,0 +1,211 @@
+package ldapgroups_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jimlambrt/gldap"
+	"github.com/statisticsnorway/labid/internal/groups"
+	"github.com/statisticsnorway/labid/internal/ldapgroups"
+)
+
+const (
+	bindDN       = "CN=labid,OU=Service,DC=ssb,DC=no"
+	bindPassword = "secret"
+	userBaseDN   = "OU=Users,DC=ssb,DC=no"
+	groupBaseDN  = "OU=Groups,DC=ssb,DC=no"
+)
+
+// directory is a minimal Active Directory stand-in. memberOf maps each user
+// or group DN to the DNs of the groups it is a direct member of.
+type directory struct {
+	users    map[string]string
+	memberOf map[string][]string
+}
+
+func (d *directory) transitiveGroups(dn string) []string {
+	var all []string
+	queue := slices.Clone(d.memberOf[dn])
+	for len(queue) > 0 {
+		g := queue[0]
+		queue = queue[1:]
+		if !slices.Contains(all, g) {
+			all = append(all, g)
+			queue = append(queue, d.memberOf[g]...)
+		}
+	}
+	return all
+}
+
+func (d *directory) bind(w *gldap.ResponseWriter, r *gldap.Request) {
+	resp := r.NewBindResponse(gldap.WithResponseCode(gldap.ResultInvalidCredentials))
+	defer w.Write(resp)
+
+	m, err := r.GetSimpleBindMessage()
+	if err == nil && m.UserName == bindDN && string(m.Password) == bindPassword {
+		resp.SetResultCode(gldap.ResultSuccess)
+	}
+}
+
+func (d *directory) search(w *gldap.ResponseWriter, r *gldap.Request) {
+	resp := r.NewSearchDoneResponse()
+	defer w.Write(resp)
+
+	m, err := r.GetSearchMessage()
+	if err != nil {
+		resp.SetResultCode(gldap.ResultOperationsError)
+		return
+	}
+
+	switch {
+	case m.BaseDN == userBaseDN && strings.HasPrefix(m.Filter, "(userPrincipalName="):
+		upn := strings.TrimSuffix(strings.TrimPrefix(m.Filter, "(userPrincipalName="), ")")
+		if dn, ok := d.users[upn]; ok {
+			w.Write(r.NewSearchResponseEntry(dn, gldap.WithAttributes(map[string][]string{
+				"memberOf": d.memberOf[dn],
+			})))
+		}
+	case m.BaseDN == groupBaseDN && strings.HasPrefix(m.Filter, "(member:1.2.840.113556.1.4.1941:="):
+		member := strings.TrimSuffix(strings.TrimPrefix(m.Filter, "(member:1.2.840.113556.1.4.1941:="), ")")
+		for _, g := range d.transitiveGroups(member) {
+			w.Write(r.NewSearchResponseEntry(g))
+		}
+	default:
+		resp.SetResultCode(gldap.ResultUnwillingToPerform)
+		return
+	}
+	resp.SetResultCode(gldap.ResultSuccess)
+}
+
+func LdapServer(t *testing.T, d *directory) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	s, err := gldap.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux, err := gldap.NewMux()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux.Bind(d.bind)
+	mux.Search(d.search)
+	s.Router(mux)
+
+	go s.Run(addr)
+	t.Cleanup(func() { s.Stop() })
+	for deadline := time.Now().Add(2 * time.Second); !s.Ready(); {
+		if time.Now().After(deadline) {
+			t.Fatal("ldap server not ready")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return fmt.Sprintf("ldap://%s", addr)
+}
+
+var testDirectory = &directory{
+	users: map[string]string{
+		"kari@ssb.no": "CN=Kari,OU=Users,DC=ssb,DC=no",
+	},
+	memberOf: map[string][]string{
+		"CN=Kari,OU=Users,DC=ssb,DC=no": {
+			"CN=dapla-felles-developers,OU=Groups,DC=ssb,DC=no",
+			"CN=Domain Users,OU=Builtin,DC=ssb,DC=no",
+		},
+		"CN=dapla-felles-developers,OU=Groups,DC=ssb,DC=no": {
+			"CN=dapla-felles-consumers,OU=Groups,DC=ssb,DC=no",
+		},
+	},
+}
+
+var daplaGroups = regexp.MustCompile(`^CN=(dapla-[^,]+),OU=Groups,`)
+
+func TestListGroupsMemberOf(t *testing.T) {
+	url := LdapServer(t, testDirectory)
+	c := ldapgroups.NewClient(url, bindDN, bindPassword, userBaseDN, ldapgroups.WithGroupPattern(daplaGroups))
+
+	got, err := c.ListGroups(context.Background(), "kari@ssb.no")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"dapla-felles-developers"}; !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestListGroupsNested(t *testing.T) {
+	url := LdapServer(t, testDirectory)
+	c := ldapgroups.NewClient(
+		url, bindDN, bindPassword, userBaseDN,
+		ldapgroups.WithNestedGroups(groupBaseDN),
+		ldapgroups.WithGroupPattern(daplaGroups),
+	)
+
+	got, err := c.ListGroups(context.Background(), "kari@ssb.no")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"dapla-felles-developers", "dapla-felles-consumers"}; !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestListGroupsErrors(t *testing.T) {
+	url := LdapServer(t, testDirectory)
+
+	c := ldapgroups.NewClient(url, bindDN, bindPassword, userBaseDN)
+	if _, err := c.ListGroups(context.Background(), "ola@ssb.no"); !errors.Is(err, groups.ErrUserNotFound) {
+		t.Fatalf("expected ErrUserNotFound, got %v", err)
+	}
+
+	c = ldapgroups.NewClient(url, bindDN, "wrong", userBaseDN)
+	if _, err := c.ListGroups(context.Background(), "kari@ssb.no"); err == nil || errors.Is(err, groups.ErrUnavailable) {
+		t.Fatalf("expected permanent bind error, got %v", err)
+	}
+
+	c = ldapgroups.NewClient("ldap://127.0.0.1:1", bindDN, bindPassword, userBaseDN, ldapgroups.WithTimeout(time.Second))
+	if _, err := c.ListGroups(context.Background(), "kari@ssb.no"); !errors.Is(err, groups.ErrUnavailable) {
+		t.Fatalf("expected ErrUnavailable, got %v", err)
+	}
+}
+
+func TestListGroupsCancelledDuringBind(t *testing.T) {
+	// Accepts connections but never answers the bind
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	c := ldapgroups.NewClient("ldap://"+ln.Addr().String(), bindDN, bindPassword, userBaseDN)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err := c.ListGroups(ctx, "kari@ssb.no"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("expected bind to be aborted on cancellation, took %s", elapsed)
+	}
+}
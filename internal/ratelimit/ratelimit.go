@@ -0,0 +1,170 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"golang.org/x/time/rate"
+)
+
+// Buckets which have not been used for this long are forgotten
+const idleTimeout = 10 * time.Minute
+
+var throttled metric.Int64Counter
+
+func init() {
+	var err error
+	throttled, err = otel.Meter("github.com/statisticsnorway/labid/internal/ratelimit").Int64Counter(
+		"labid.ratelimit.throttled",
+		metric.WithDescription("Number of requests rejected by a rate limiter"),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		otel.Handle(err)
+	}
+}
+
+// LimitedError is returned when a request exceeds its rate limit.
+type LimitedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LimitedError) Error() string {
+	return fmt.Sprintf("rate limit exceeded, retry after %s", e.RetryAfter)
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// Limiter is a set of token buckets, one per key.
+type Limiter struct {
+	name  string
+	limit rate.Limit
+	burst int
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// New creates a limiter which allows perSecond requests per key on average,
+// with bursts of up to burst requests. name is used in metrics.
+func New(name string, perSecond float64, burst int) *Limiter {
+	return &Limiter{
+		name:    name,
+		limit:   rate.Limit(perSecond),
+		burst:   burst,
+		buckets: map[string]*bucket{},
+	}
+}
+
+// Reserve takes a token from the bucket of key. If none is available it
+// returns false and how long the caller should wait before retrying.
+func (l *Limiter) Reserve(key string) (time.Duration, bool) {
+	now := time.Now()
+
+	l.mu.Lock()
+	if now.Sub(l.lastSweep) > idleTimeout {
+		for k, b := range l.buckets {
+			if now.Sub(b.lastSeen) > idleTimeout {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.buckets[key] = b
+	}
+	b.lastSeen = now
+	l.mu.Unlock()
+
+	r := b.limiter.ReserveN(now, 1)
+	if !r.OK() {
+		throttled.Add(context.Background(), 1, metric.WithAttributes(attribute.String("limiter", l.name)))
+		return time.Second, false
+	}
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		throttled.Add(context.Background(), 1, metric.WithAttributes(attribute.String("limiter", l.name)))
+		return delay, false
+	}
+	return 0, true
+}
+
+// Middleware rejects requests exceeding the limit for the key returned by
+// keyFunc.
+func (l *Limiter) Middleware(keyFunc func(*http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if retryAfter, ok := l.Reserve(keyFunc(r)); !ok {
+				WriteTooManyRequests(w, retryAfter)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ClientIP returns a key function using the client IP. If header is set, e.g.
+// X-Forwarded-For, the last address in it is used, which is the one added by
+// the closest proxy. Only use header when LabID sits behind a trusted proxy.
+func ClientIP(header string) func(*http.Request) string {
+	return func(r *http.Request) string {
+		if header != "" {
+			if values := r.Header.Values(header); len(values) > 0 {
+				addrs := strings.Split(values[len(values)-1], ",")
+				if ip := strings.TrimSpace(addrs[len(addrs)-1]); ip != "" {
+					return ip
+				}
+			}
+		}
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return r.RemoteAddr
+		}
+		return host
+	}
+}
+
+// WriteTooManyRequests writes a 429 response as described in RFC 6585, with
+// Retry-After in whole seconds. The error code is temporarily_unavailable, as
+// RFC 6749 has no code for rate limiting.
+func WriteTooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":             "temporarily_unavailable",
+		"error_description": "rate limit exceeded",
+	})
+}
+
+// ErrorHandler wraps an ogen error handler, responding with 429 to errors
+// caused by a LimitedError and delegating everything else to next.
+func ErrorHandler(next func(context.Context, http.ResponseWriter, *http.Request, error)) func(context.Context, http.ResponseWriter, *http.Request, error) {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request, err error) {
+		var limited *LimitedError
+		if errors.As(err, &limited) {
+			WriteTooManyRequests(w, limited.RetryAfter)
+			return
+		}
+		next(ctx, w, r, err)
+	}
+}
@@ -0,0 +1,80 @@
+package ratelimit_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/statisticsnorway/labid/internal/ratelimit"
+)
+
+func TestReserveBurst(t *testing.T) {
+	l := ratelimit.New("test", 1, 2)
+
+	for i := range 2 {
+		if _, ok := l.Reserve("a"); !ok {
+			t.Fatalf("request %d within burst was throttled", i)
+		}
+	}
+	retryAfter, ok := l.Reserve("a")
+	if ok {
+		t.Fatal("request exceeding burst was allowed")
+	}
+	if retryAfter <= 0 || retryAfter > time.Second {
+		t.Fatalf("unexpected retry after %s", retryAfter)
+	}
+	if _, ok := l.Reserve("b"); !ok {
+		t.Fatal("keys must not share buckets")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	l := ratelimit.New("test", 0.1, 1)
+	h := l.Middleware(ratelimit.ClientIP("X-Forwarded-For"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := func(forwardedFor string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/token", nil)
+		r.Header.Set("X-Forwarded-For", forwardedFor)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := req("10.0.0.1, 10.0.0.2"); w.Code != http.StatusOK {
+		t.Fatalf("expected first request to pass, got %d", w.Code)
+	}
+	w := req("10.0.0.3, 10.0.0.2")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 for same closest proxy address, got %d", w.Code)
+	}
+	if retryAfter := w.Header().Get("Retry-After"); retryAfter != "10" {
+		t.Fatalf("expected Retry-After 10, got %q", retryAfter)
+	}
+	if w := req("10.0.0.4"); w.Code != http.StatusOK {
+		t.Fatalf("expected other client to pass, got %d", w.Code)
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	var fallback bool
+	h := ratelimit.ErrorHandler(func(context.Context, http.ResponseWriter, *http.Request, error) {
+		fallback = true
+	})
+
+	w := httptest.NewRecorder()
+	err := fmt.Errorf("wrapped: %w", &ratelimit.LimitedError{RetryAfter: 1500 * time.Millisecond})
+	h(context.Background(), w, httptest.NewRequest("POST", "/token", nil), err)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "2" {
+		t.Fatalf("unexpected response, status=%d, retry-after=%q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	h(context.Background(), httptest.NewRecorder(), httptest.NewRequest("POST", "/token", nil), fmt.Errorf("other"))
+	if !fallback {
+		t.Fatal("expected other errors to be handled by fallback")
+	}
+}
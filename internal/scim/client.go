@@ -0,0 +1,252 @@
+package scim
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/statisticsnorway/labid/internal/groups"
+	"github.com/statisticsnorway/labid/internal/upstream"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+const (
+	contentType      = "application/scim+json"
+	listResponseURN  = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	errorResponseURN = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+type Client struct {
+	httpClient  *http.Client
+	baseUrl     string
+	groupSearch bool
+	pageSize    int
+	backoff     upstream.Backoff
+	breaker     *upstream.Breaker
+}
+
+type optFunc func(*Client)
+
+// WithGroupSearch finds the groups of a user by searching /Groups for the
+// user as member, for servers that do not return the groups attribute of
+// users.
+func WithGroupSearch(enabled bool) optFunc {
+	return func(c *Client) {
+		c.groupSearch = enabled
+	}
+}
+
+// WithPageSize sets how many groups are fetched per request when searching
+// groups.
+func WithPageSize(n int) optFunc {
+	return func(c *Client) {
+		c.pageSize = n
+	}
+}
+
+func WithBackoff(b upstream.Backoff) optFunc {
+	return func(c *Client) {
+		c.backoff = b
+	}
+}
+
+func WithBreaker(b *upstream.Breaker) optFunc {
+	return func(c *Client) {
+		c.breaker = b
+	}
+}
+
+// NewClient creates a group provider for a SCIM 2.0 service at baseUrl,
+// authenticating with OAuth client credentials.
+func NewClient(baseUrl, tokenUrl, clientId, clientSecret string, scopes []string, opts ...optFunc) *Client {
+	httpClient := (&clientcredentials.Config{
+		ClientID:     clientId,
+		ClientSecret: clientSecret,
+		TokenURL:     tokenUrl,
+		Scopes:       scopes,
+	}).Client(context.Background())
+	httpClient.Timeout = time.Second * 10
+
+	c := &Client{
+		httpClient: httpClient,
+		baseUrl:    strings.TrimSuffix(baseUrl, "/"),
+		pageSize:   100,
+		backoff:    upstream.DefaultBackoff,
+		breaker:    upstream.NewBreaker("scim", 5, 30*time.Second),
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+type ListResponse[T any] struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int      `json:"totalResults"`
+	StartIndex   int      `json:"startIndex"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	Resources    []T      `json:"Resources"`
+}
+
+type GroupRef struct {
+	Value   string `json:"value"`
+	Display string `json:"display"`
+}
+
+type User struct {
+	Id       string     `json:"id"`
+	UserName string     `json:"userName"`
+	Groups   []GroupRef `json:"groups"`
+}
+
+type Group struct {
+	Id          string `json:"id"`
+	DisplayName string `json:"displayName"`
+}
+
+// ErrorResponse is the SCIM error schema, see RFC 7644 section 3.12.
+type ErrorResponse struct {
+	Schemas  []string `json:"schemas"`
+	ScimType string   `json:"scimType"`
+	Detail   string   `json:"detail"`
+}
+
+// ListGroups returns the display names of the groups of the user. Groups
+// without a display name are left out. Transient failures are retried, and
+// errors wrap groups.ErrUserNotFound or groups.ErrUnavailable where
+// applicable.
+func (c *Client) ListGroups(ctx context.Context, userPrincipalEmail string) ([]string, error) {
+	return upstream.Call(ctx, c.backoff, c.breaker, groups.ErrUnavailable, []error{groups.ErrUserNotFound}, func(ctx context.Context) ([]string, error) {
+		return c.listGroups(ctx, userPrincipalEmail)
+	})
+}
+
+func (c *Client) listGroups(ctx context.Context, userPrincipalEmail string) ([]string, error) {
+	attributes := "id,userName,groups"
+	if c.groupSearch {
+		attributes = "id,userName"
+	}
+	users, err := list[User](ctx, c, "/Users", url.Values{
+		"filter":     {fmt.Sprintf("userName eq %s", quote(userPrincipalEmail))},
+		"attributes": {attributes},
+		"count":      {"2"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("search user %q: %w", userPrincipalEmail, err)
+	}
+	switch len(users.Resources) {
+	case 0:
+		return nil, fmt.Errorf("scim could not find user %q: %w", userPrincipalEmail, groups.ErrUserNotFound)
+	case 1:
+	default:
+		return nil, fmt.Errorf("scim found %d users matching %q", users.TotalResults, userPrincipalEmail)
+	}
+	user := users.Resources[0]
+
+	var names []string
+	if !c.groupSearch {
+		for _, g := range user.Groups {
+			if g.Display != "" {
+				names = append(names, g.Display)
+			}
+		}
+		return names, nil
+	}
+
+	for startIndex := 1; ; {
+		page, err := list[Group](ctx, c, "/Groups", url.Values{
+			"filter":     {fmt.Sprintf("members[value eq %s]", quote(user.Id))},
+			"attributes": {"id,displayName"},
+			"startIndex": {strconv.Itoa(startIndex)},
+			"count":      {strconv.Itoa(c.pageSize)},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("search groups of %q: %w", userPrincipalEmail, err)
+		}
+		for _, g := range page.Resources {
+			if g.DisplayName != "" {
+				names = append(names, g.DisplayName)
+			}
+		}
+		// Servers may return fewer results than requested, so only stop on
+		// an empty page or when all results have been fetched
+		startIndex += len(page.Resources)
+		if len(page.Resources) == 0 || startIndex > page.TotalResults {
+			return names, nil
+		}
+	}
+}
+
+// list queries a SCIM list endpoint, e.g. /Users, and decodes the list
+// response.
+func list[T any](ctx context.Context, c *Client, path string, query url.Values) (ListResponse[T], error) {
+	var l ListResponse[T]
+	endpoint := fmt.Sprintf("%s%s?%s", c.baseUrl, path, query.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return l, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Accept", contentType)
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return l, err
+		}
+		return l, fmt.Errorf("%w: %w", groups.ErrUnavailable, err)
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		if err := json.NewDecoder(res.Body).Decode(&l); err != nil {
+			return l, fmt.Errorf("decode list response: %w", err)
+		}
+		if !slices.Contains(l.Schemas, listResponseURN) {
+			return l, fmt.Errorf("response is not a scim list response, schemas %v", l.Schemas)
+		}
+		return l, nil
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return l, fmt.Errorf("scim returned %q%s: %w", res.Status, errorDetail(res.Body), groups.ErrUnavailable)
+	default:
+		return l, fmt.Errorf("scim returned %q%s", res.Status, errorDetail(res.Body))
+	}
+}
+
+// errorDetail formats the scimType and detail of an error response, or
+// returns an empty string if the body is not a SCIM error.
+func errorDetail(body io.Reader) string {
+	var e ErrorResponse
+	if err := json.NewDecoder(io.LimitReader(body, 64<<10)).Decode(&e); err != nil {
+		return ""
+	}
+	if !slices.Contains(e.Schemas, errorResponseURN) {
+		return ""
+	}
+	switch {
+	case e.ScimType != "" && e.Detail != "":
+		return fmt.Sprintf(", %s: %s", e.ScimType, e.Detail)
+	case e.ScimType != "":
+		return ", " + e.ScimType
+	case e.Detail != "":
+		return ", " + e.Detail
+	default:
+		return ""
+	}
+}
+
+// quote returns s as a SCIM filter string literal, which is a JSON string.
+func quote(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
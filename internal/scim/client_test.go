@@ -0,0 +1,158 @@
+package scim_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/statisticsnorway/labid/internal/groups"
+	"github.com/statisticsnorway/labid/internal/scim"
+	"github.com/statisticsnorway/labid/internal/upstream"
+)
+
+var fastBackoff = scim.WithBackoff(upstream.Backoff{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+
+var (
+	users = map[string]scim.User{
+		"kari@ssb.no": {
+			Id:       "2819c223",
+			UserName: "kari@ssb.no",
+			Groups: []scim.GroupRef{
+				{Value: "e9e30dba", Display: "dapla-felles-developers"},
+				{Value: "fc348aa8", Display: "dapla-felles-consumers"},
+				{Value: "6c5bb468"},
+			},
+		},
+	}
+	groupNames = []string{"dapla-felles-developers", "dapla-felles-consumers", "play-foeniks-developers"}
+)
+
+func writeScim(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/scim+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeList[T any](w http.ResponseWriter, total, startIndex int, resources []T) {
+	writeScim(w, http.StatusOK, scim.ListResponse[T]{
+		Schemas:      []string{"urn:ietf:params:scim:api:messages:2.0:ListResponse"},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	})
+}
+
+// ScimServer serves a client credentials token endpoint, and /Users and
+// /Groups with the filters used by the client. Group searches return at most
+// two groups per page regardless of the requested count.
+func ScimServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"token","token_type":"Bearer","expires_in":3600}`)
+	})
+	mux.HandleFunc("GET /Users", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var userName string
+		if _, err := fmt.Sscanf(r.URL.Query().Get("filter"), "userName eq %q", &userName); err != nil {
+			writeScim(w, http.StatusBadRequest, scim.ErrorResponse{
+				Schemas:  []string{"urn:ietf:params:scim:api:messages:2.0:Error"},
+				ScimType: "invalidFilter",
+				Detail:   "unsupported filter",
+			})
+			return
+		}
+		if user, ok := users[userName]; ok {
+			writeList(w, 1, 1, []scim.User{user})
+			return
+		}
+		writeList(w, 0, 1, []scim.User{})
+	})
+	mux.HandleFunc("GET /Groups", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("filter") != `members[value eq "2819c223"]` {
+			writeList(w, 0, 1, []scim.Group{})
+			return
+		}
+		startIndex, _ := strconv.Atoi(r.URL.Query().Get("startIndex"))
+		var page []scim.Group
+		for i := startIndex - 1; i < len(groupNames) && len(page) < 2; i++ {
+			page = append(page, scim.Group{Id: strconv.Itoa(i), DisplayName: groupNames[i]})
+		}
+		writeList(w, len(groupNames), startIndex, page)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestListGroupsFromUser(t *testing.T) {
+	srv := ScimServer(t)
+	c := scim.NewClient(srv.URL, srv.URL+"/token", "id", "secret", nil, fastBackoff)
+
+	got, err := c.ListGroups(context.Background(), "kari@ssb.no")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"dapla-felles-developers", "dapla-felles-consumers"}; !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestListGroupsPaginatesGroupSearch(t *testing.T) {
+	srv := ScimServer(t)
+	c := scim.NewClient(srv.URL, srv.URL+"/token", "id", "secret", nil, fastBackoff, scim.WithGroupSearch(true), scim.WithPageSize(10))
+
+	got, err := c.ListGroups(context.Background(), "kari@ssb.no")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, groupNames) {
+		t.Fatalf("expected %v, got %v", groupNames, got)
+	}
+}
+
+func TestListGroupsErrors(t *testing.T) {
+	srv := ScimServer(t)
+	c := scim.NewClient(srv.URL, srv.URL+"/token", "id", "secret", nil, fastBackoff)
+
+	if _, err := c.ListGroups(context.Background(), "ola@ssb.no"); !errors.Is(err, groups.ErrUserNotFound) {
+		t.Fatalf("expected ErrUserNotFound, got %v", err)
+	}
+
+	// Quotes in the user name would break out of the filter if not escaped
+	_, err := c.ListGroups(context.Background(), `kari@ssb.no" or userName pr or "`)
+	if !errors.Is(err, groups.ErrUserNotFound) {
+		t.Fatalf("expected ErrUserNotFound, got %v", err)
+	}
+
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"access_token":"token","token_type":"Bearer","expires_in":3600}`)
+			return
+		}
+		writeScim(w, http.StatusServiceUnavailable, scim.ErrorResponse{
+			Schemas: []string{"urn:ietf:params:scim:api:messages:2.0:Error"},
+			Detail:  "maintenance",
+		})
+	}))
+	defer unavailable.Close()
+
+	c = scim.NewClient(unavailable.URL, unavailable.URL+"/token", "id", "secret", nil, fastBackoff)
+	_, err = c.ListGroups(context.Background(), "kari@ssb.no")
+	if !errors.Is(err, groups.ErrUnavailable) || !strings.Contains(err.Error(), "maintenance") {
+		t.Fatalf("expected ErrUnavailable with scim error detail, got %v", err)
+	}
+}
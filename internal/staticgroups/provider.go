@@ -0,0 +1,157 @@
+package staticgroups
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/statisticsnorway/labid/internal/filewatch"
+	"github.com/statisticsnorway/labid/internal/groups"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/yaml"
+)
+
+// Mapping is the format of the groups file, in YAML or JSON:
+//
+//	users:
+//	  kari@ssb.no:
+//	    - dapla-felles-developers
+type Mapping struct {
+	Users map[string][]string `json:"users"`
+}
+
+// Provider serves groups from a static mapping of users to groups, e.g. for
+// local development, tests or clusters without access to a group API.
+type Provider struct {
+	users atomic.Pointer[map[string][]string]
+}
+
+func Parse(data []byte) (map[string][]string, error) {
+	var m Mapping
+	if err := yaml.UnmarshalStrict(data, &m); err != nil {
+		return nil, fmt.Errorf("unmarshal groups mapping: %w", err)
+	}
+	users := make(map[string][]string, len(m.Users))
+	for user, userGroups := range m.Users {
+		users[strings.ToLower(user)] = userGroups
+	}
+	return users, nil
+}
+
+func (p *Provider) load(data []byte) error {
+	users, err := Parse(data)
+	if err != nil {
+		return err
+	}
+	p.users.Store(&users)
+	return nil
+}
+
+func (p *Provider) ListGroups(_ context.Context, userPrincipalEmail string) ([]string, error) {
+	userGroups, ok := (*p.users.Load())[strings.ToLower(userPrincipalEmail)]
+	if !ok {
+		return nil, fmt.Errorf("static groups has no user %q: %w", userPrincipalEmail, groups.ErrUserNotFound)
+	}
+	return userGroups, nil
+}
+
+// NewFromFile loads the mapping from path, and reloads it every interval if
+// it has changed until ctx is cancelled. If a reload fails, the previous
+// mapping is kept.
+func NewFromFile(ctx context.Context, path string, interval time.Duration) (*Provider, error) {
+	p := &Provider{}
+	watcher := filewatch.New(path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read groups file: %w", err)
+	}
+	if err := p.load(data); err != nil {
+		return nil, err
+	}
+
+	go watcher.Poll(ctx, interval, func() {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			err = p.load(data)
+		}
+		if err != nil {
+			slog.Error("reload groups file", "path", path, "error", err.Error())
+			return
+		}
+		slog.Info("reloaded groups file", "path", path)
+	})
+
+	return p, nil
+}
+
+// NewFromConfigMap loads the mapping from key in the given ConfigMap, and
+// watches it for changes until ctx is cancelled. It fails if the ConfigMap
+// cannot be parsed at startup. If a later update cannot be parsed, the
+// previous mapping is kept.
+func NewFromConfigMap(ctx context.Context, client kubernetes.Interface, namespace, name, key string) (*Provider, error) {
+	p := &Provider{}
+	empty := map[string][]string{}
+	p.users.Store(&empty)
+
+	factory := informers.NewSharedInformerFactoryWithOptions(
+		client,
+		0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}),
+	)
+
+	load := func(cm *corev1.ConfigMap) error {
+		data, ok := cm.Data[key]
+		if !ok {
+			return fmt.Errorf("configmap has no key %q", key)
+		}
+		return p.load([]byte(data))
+	}
+	// Errors of the initial load are returned after the sync instead
+	var synced atomic.Bool
+	update := func(obj any) {
+		cm, ok := obj.(*corev1.ConfigMap)
+		if !ok || !synced.Load() {
+			return
+		}
+		if err := load(cm); err != nil {
+			slog.Error("reload groups configmap", "namespace", namespace, "name", name, "error", err.Error())
+			return
+		}
+		slog.Info("reloaded groups configmap", "namespace", namespace, "name", name)
+	}
+	informer := factory.Core().V1().ConfigMaps().Informer()
+	registration, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    update,
+		UpdateFunc: func(_, obj any) { update(obj) },
+	})
+	if err != nil {
+		return nil, fmt.Errorf("add configmap event handler: %w", err)
+	}
+
+	factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), registration.HasSynced) {
+		return nil, fmt.Errorf("sync configmap %s/%s", namespace, name)
+	}
+	synced.Store(true)
+	cms := informer.GetStore().List()
+	if len(cms) == 0 {
+		return nil, fmt.Errorf("configmap %s/%s not found", namespace, name)
+	}
+	if err := load(cms[0].(*corev1.ConfigMap)); err != nil {
+		return nil, fmt.Errorf("load configmap %s/%s: %w", namespace, name, err)
+	}
+
+	return p, nil
+}
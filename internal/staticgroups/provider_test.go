@@ -0,0 +1,109 @@
+package staticgroups_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/statisticsnorway/labid/internal/groups"
+	"github.com/statisticsnorway/labid/internal/staticgroups"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func WaitForGroups(t *testing.T, p groups.Provider, email string, want []string) {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		got, err := p.ListGroups(context.Background(), email)
+		if err == nil && slices.Equal(got, want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected groups %v for %q, got %v (err=%v)", want, email, got, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestParse(t *testing.T) {
+	yamlUsers, err := staticgroups.Parse([]byte("users:\n  Kari@ssb.no:\n    - a\n    - b\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonUsers, err := staticgroups.Parse([]byte(`{"users": {"kari@ssb.no": ["a", "b"]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(yamlUsers["kari@ssb.no"], jsonUsers["kari@ssb.no"]) {
+		t.Fatalf("yaml and json differ, yaml=%v, json=%v", yamlUsers, jsonUsers)
+	}
+
+	if _, err := staticgroups.Parse([]byte("user:\n  kari@ssb.no: [a]\n")); err == nil {
+		t.Fatal("expected error for unknown field")
+	}
+}
+
+func TestFileReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "groups.yaml")
+	if err := os.WriteFile(path, []byte("users:\n  kari@ssb.no: [a]\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p, err := staticgroups.NewFromFile(ctx, path, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	WaitForGroups(t, p, "kari@ssb.no", []string{"a"})
+	if _, err := p.ListGroups(ctx, "ola@ssb.no"); !errors.Is(err, groups.ErrUserNotFound) {
+		t.Fatalf("expected ErrUserNotFound, got %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("users:\n  kari@ssb.no: [a, b]\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	WaitForGroups(t, p, "kari@ssb.no", []string{"a", "b"})
+}
+
+func TestConfigMapReload(t *testing.T) {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "groups", Namespace: "labid"},
+		Data:       map[string]string{"groups.yaml": "users:\n  kari@ssb.no: [a]\n"},
+	}
+	client := fake.NewClientset(cm)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p, err := staticgroups.NewFromConfigMap(ctx, client, "labid", "groups", "groups.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	WaitForGroups(t, p, "kari@ssb.no", []string{"a"})
+
+	cm.Data["groups.yaml"] = "users:\n  kari@ssb.no: [a, b]\n"
+	if _, err := client.CoreV1().ConfigMaps("labid").Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	WaitForGroups(t, p, "kari@ssb.no", []string{"a", "b"})
+}
+
+func TestConfigMapInvalidAtStartup(t *testing.T) {
+	for name, data := range map[string]map[string]string{
+		"invalid yaml": {"groups.yaml": "users: [kari@ssb.no]\n"},
+		"missing key":  {"other.yaml": "users:\n  kari@ssb.no: [a]\n"},
+	} {
+		client := fake.NewClientset(&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "groups", Namespace: "labid"},
+			Data:       data,
+		})
+		if _, err := staticgroups.NewFromConfigMap(t.Context(), client, "labid", "groups", "groups.yaml"); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
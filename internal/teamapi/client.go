@@ -7,18 +7,32 @@ import (
 	"net/http"
 	"time"
 
-	"github.com/lestrrat-go/jwx/v3/jwt"
-	"github.com/statisticsnorway/labid/internal/token"
+	"github.com/statisticsnorway/labid/internal/groups"
+	"github.com/statisticsnorway/labid/internal/upstream"
 	"golang.org/x/oauth2/clientcredentials"
 )
 
 type client struct {
 	httpClient *http.Client
 	teamApiUrl string
+	backoff    upstream.Backoff
+	breaker    *upstream.Breaker
 }
 
 type optFunc func(*client)
 
+func WithBackoff(b upstream.Backoff) optFunc {
+	return func(c *client) {
+		c.backoff = b
+	}
+}
+
+func WithBreaker(b *upstream.Breaker) optFunc {
+	return func(c *client) {
+		c.breaker = b
+	}
+}
+
 func NewClient(teamApiUrl, tokenUrl, clientId, clientSecret string, opts ...optFunc) *client {
 	httpClient := (&clientcredentials.Config{
 		ClientID:     clientId,
@@ -30,6 +44,8 @@ func NewClient(teamApiUrl, tokenUrl, clientId, clientSecret string, opts ...optF
 	c := &client{
 		httpClient: httpClient,
 		teamApiUrl: teamApiUrl,
+		backoff:    upstream.DefaultBackoff,
+		breaker:    upstream.NewBreaker("team-api", 5, 30*time.Second),
 	}
 
 	for _, opt := range opts {
@@ -51,44 +67,52 @@ type GroupsResponse struct {
 	Groups []Group `json:"groups"`
 }
 
-func (c *client) ListGroups(userPrincipalEmail string) ([]string, error) {
+// ListGroups returns the groups of the user. Transient failures are retried,
+// and errors wrap groups.ErrUserNotFound or groups.ErrUnavailable where
+// applicable.
+func (c *client) ListGroups(ctx context.Context, userPrincipalEmail string) ([]string, error) {
+	return upstream.Call(ctx, c.backoff, c.breaker, groups.ErrUnavailable, []error{groups.ErrUserNotFound}, func(ctx context.Context) ([]string, error) {
+		return c.listGroups(ctx, userPrincipalEmail)
+	})
+}
+
+func (c *client) listGroups(ctx context.Context, userPrincipalEmail string) ([]string, error) {
 	endpoint := fmt.Sprintf("%s/users/%s/groups", c.teamApiUrl, userPrincipalEmail)
 
-	res, err := c.httpClient.Get(endpoint)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
 	if err != nil {
-		return nil, fmt.Errorf("get user groups for %q: %w", userPrincipalEmail, err)
+		return nil, fmt.Errorf("create request for user groups: %w", err)
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("get user groups for %q: %w", userPrincipalEmail, err)
+		}
+		return nil, fmt.Errorf("get user groups for %q: %w: %w", userPrincipalEmail, groups.ErrUnavailable, err)
 	}
 	defer res.Body.Close()
 
 	switch res.StatusCode {
 	case http.StatusOK:
-		var groups EmbeddedResponse[GroupsResponse]
+		var groupsRes EmbeddedResponse[GroupsResponse]
 		dec := json.NewDecoder(res.Body)
-		if err := dec.Decode(&groups); err != nil {
+		if err := dec.Decode(&groupsRes); err != nil {
 			return nil, fmt.Errorf("decode userinfo response: %w", err)
 		}
 		var flatGroups []string
-		for _, g := range groups.Embedded.Groups {
+		for _, g := range groupsRes.Embedded.Groups {
 			flatGroups = append(flatGroups, g.UniformName)
 		}
 		return flatGroups, nil
 	case http.StatusNotFound:
-		return nil, fmt.Errorf("team api could not find user %q", userPrincipalEmail)
-	case http.StatusUnauthorized, http.StatusForbidden, http.StatusInternalServerError:
+		return nil, fmt.Errorf("team api could not find user %q: %w", userPrincipalEmail, groups.ErrUserNotFound)
+	case http.StatusUnauthorized, http.StatusForbidden:
 		return nil, fmt.Errorf("get user groups for %q, team api returned %q", userPrincipalEmail, res.Status)
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return nil, fmt.Errorf("get user groups for %q, team api returned %q: %w", userPrincipalEmail, res.Status, groups.ErrUnavailable)
 	default:
 		return nil, fmt.Errorf("get user groups for %q, team api returned unknown status %q", userPrincipalEmail, res.Status)
 	}
 }
-
-func (c *client) AllGroupsPopulator(ctx context.Context, username string) token.Mapper {
-	return func(ctx context.Context, builder *jwt.Builder) error {
-		groups, err := c.ListGroups(fmt.Sprintf("%s@ssb.no", username))
-		if err != nil {
-			return err
-		}
-
-		builder.Claim("dapla.groups", groups)
-		return nil
-	}
-}
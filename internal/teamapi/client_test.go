@@ -0,0 +1,116 @@
+package teamapi_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/statisticsnorway/labid/internal/groups"
+	"github.com/statisticsnorway/labid/internal/teamapi"
+	"github.com/statisticsnorway/labid/internal/upstream"
+)
+
+var fastBackoff = teamapi.WithBackoff(upstream.Backoff{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+
+// TeamApi serves a client credentials token endpoint and delegates group
+// lookups to groupsHandler.
+func TeamApi(groupsHandler http.HandlerFunc) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"token","token_type":"Bearer","expires_in":3600}`)
+	})
+	mux.HandleFunc("GET /users/{email}/groups", groupsHandler)
+	return httptest.NewServer(mux)
+}
+
+func TestListGroupsRetriesTransientFailures(t *testing.T) {
+	var calls int
+	srv := TeamApi(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{"_embedded":{"groups":[{"uniform_name":"dapla-felles-developers"}]}}`)
+	})
+	defer srv.Close()
+
+	c := teamapi.NewClient(srv.URL, srv.URL+"/token", "id", "secret", fastBackoff)
+	got, err := c.ListGroups(context.Background(), "kari@ssb.no")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, []string{"dapla-felles-developers"}) || calls != 2 {
+		t.Fatalf("unexpected result, groups=%v, calls=%d", got, calls)
+	}
+}
+
+func TestListGroupsTypedErrors(t *testing.T) {
+	srv := TeamApi(func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("email") == "unknown@ssb.no" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	defer srv.Close()
+
+	c := teamapi.NewClient(srv.URL, srv.URL+"/token", "id", "secret", fastBackoff)
+
+	if _, err := c.ListGroups(context.Background(), "unknown@ssb.no"); !errors.Is(err, groups.ErrUserNotFound) {
+		t.Fatalf("expected ErrUserNotFound, got %v", err)
+	}
+	if _, err := c.ListGroups(context.Background(), "kari@ssb.no"); !errors.Is(err, groups.ErrUnavailable) {
+		t.Fatalf("expected ErrUnavailable, got %v", err)
+	}
+}
+
+func TestListGroupsBreakerOpens(t *testing.T) {
+	var calls int
+	srv := TeamApi(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	defer srv.Close()
+
+	c := teamapi.NewClient(
+		srv.URL, srv.URL+"/token", "id", "secret",
+		fastBackoff,
+		teamapi.WithBreaker(upstream.NewBreaker("test", 1, time.Minute)),
+	)
+
+	c.ListGroups(context.Background(), "kari@ssb.no")
+	calls = 0
+	_, err := c.ListGroups(context.Background(), "kari@ssb.no")
+	if !errors.Is(err, upstream.ErrCircuitOpen) || !errors.Is(err, groups.ErrUnavailable) {
+		t.Fatalf("expected open circuit, got %v", err)
+	}
+	if calls != 0 {
+		t.Fatalf("expected no upstream calls while open, got %d", calls)
+	}
+}
+
+func TestListGroupsUsesContext(t *testing.T) {
+	srv := TeamApi(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	defer srv.Close()
+
+	c := teamapi.NewClient(srv.URL, srv.URL+"/token", "id", "secret", fastBackoff)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := c.ListGroups(ctx, "kari@ssb.no"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("request was not cancelled with the context, took %s", elapsed)
+	}
+}
@@ -0,0 +1,135 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/statisticsnorway/labid/internal/filewatch"
+)
+
+type Reloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+	watcher      *filewatch.Watcher
+
+	cert      atomic.Pointer[tls.Certificate]
+	clientCAs atomic.Pointer[x509.CertPool]
+}
+
+// NewReloader loads the certificate, key and optional client CA bundle from
+// disk. clientCAFile may be empty, in which case client certificates are not
+// requested.
+func NewReloader(certFile, keyFile, clientCAFile string) (*Reloader, error) {
+	r := &Reloader{
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: clientCAFile,
+	}
+	paths := []string{certFile, keyFile}
+	if clientCAFile != "" {
+		paths = append(paths, clientCAFile)
+	}
+	r.watcher = filewatch.New(paths...)
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Reloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("load key pair: %w", err)
+	}
+
+	var pool *x509.CertPool
+	if r.clientCAFile != "" {
+		rawCAs, err := os.ReadFile(r.clientCAFile)
+		if err != nil {
+			return fmt.Errorf("read client ca file: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(rawCAs) {
+			return errors.New("client ca file contains no certificates")
+		}
+	}
+
+	r.cert.Store(&cert)
+	r.clientCAs.Store(pool)
+	return nil
+}
+
+// Watch reloads the certificates whenever the files change. A failed reload
+// keeps the previous certificates in use.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	r.watcher.Poll(ctx, interval, func() {
+		if err := r.Reload(); err != nil {
+			slog.Error("reload tls certificates", "error", err.Error())
+			return
+		}
+		slog.Info("reloaded tls certificates")
+	})
+}
+
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
+
+// Config returns a server TLS config that always serves the most recently
+// loaded certificate and client CAs. If a client CA file was given, client
+// certificates are verified against it when presented, and RequireClientCert
+// rejects requests without one.
+func (r *Reloader) Config(minVersion uint16) *tls.Config {
+	base := &tls.Config{
+		MinVersion:     minVersion,
+		GetCertificate: r.GetCertificate,
+	}
+	if r.clientCAFile == "" {
+		return base
+	}
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		c := base.Clone()
+		c.GetConfigForClient = nil
+		c.ClientCAs = r.clientCAs.Load()
+		c.ClientAuth = tls.VerifyClientCertIfGiven
+		return c, nil
+	}
+	return base
+}
+
+// RequireClientCert rejects requests without a verified client certificate
+// with 401, except on the exempt paths, e.g. the probes of the kubelet, which
+// has no client certificate.
+func RequireClientCert(exempt ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if slices.Contains(exempt, r.URL.Path) || (r.TLS != nil && len(r.TLS.VerifiedChains) > 0) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			http.Error(w, "client certificate required", http.StatusUnauthorized)
+		})
+	}
+}
+
+func ParseVersion(v string) (uint16, error) {
+	switch strings.TrimPrefix(v, "TLS") {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported tls version %q, must be 1.2 or 1.3", v)
+	}
+}
@@ -1,8 +1,9 @@
 package token
 
+import "github.com/statisticsnorway/labid/internal/identity"
+
 const (
 	DaplaGroupAnnotation = "dapla.ssb.no/impersonate-group"
-	UserNamespacePrefix  = "user-ssb-"
 )
 
 type KubernetesMeta struct {
@@ -10,8 +11,18 @@ type KubernetesMeta struct {
 	Namespace string
 }
 
+// ReservedClaims are set by LabID itself, and cannot be set by claim
+// mappings.
+var ReservedClaims = []string{
+	"sub", "iss", "aud", "exp", "iat", "nbf", "jti", "scope",
+	"email", "dapla.group", "dapla.groups",
+}
+
+// MapperContext describes the exchange a token is issued for.
 type MapperContext struct {
-	Username       string
+	Identity       identity.Identity
 	ServiceAccount KubernetesMeta
 	Pod            KubernetesMeta
+	Audience       []string
+	Scopes         []string
 }
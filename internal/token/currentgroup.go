@@ -3,11 +3,16 @@ package token
 import (
 	"context"
 	"errors"
+	"fmt"
 
 	"github.com/lestrrat-go/jwx/v3/jwt"
 	corev1 "k8s.io/api/core/v1"
 )
 
+// ErrNoCurrentGroup is returned by CurrentGroupMapper for service accounts
+// without the DaplaGroupAnnotation.
+var ErrNoCurrentGroup = errors.New("service account has no associated group")
+
 type ServiceAccountGetter func(ctx context.Context, name, namespace string) (*corev1.ServiceAccount, error)
 
 func CurrentGroupMapper(ctx context.Context, getSa ServiceAccountGetter) func(ctx context.Context, name, namespace string) Mapper {
@@ -21,7 +26,7 @@ func CurrentGroupMapper(ctx context.Context, getSa ServiceAccountGetter) func(ct
 				builder.Claim("dapla.group", group)
 				return nil
 			}
-			return errors.New("service account has no associated group")
+			return fmt.Errorf("%w, set the %s annotation", ErrNoCurrentGroup, DaplaGroupAnnotation)
 		}
 	}
 }
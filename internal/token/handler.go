@@ -12,6 +12,8 @@ import (
 
 	"github.com/lestrrat-go/jwx/v3/jwk"
 	api "github.com/statisticsnorway/labid/api/oas"
+	"github.com/statisticsnorway/labid/internal/identity"
+	"github.com/statisticsnorway/labid/internal/ratelimit"
 )
 
 var _ api.Handler = (*tokenHandler)(nil)
@@ -21,6 +23,10 @@ type tokenHandler struct {
 	TokenIssuer          TokenIssuer
 	PopulateCurrentGroup CurrentGroupPopulator
 	PopulateAllGroups    AllGroupsPopulator
+	RateLimiter          RateLimiter
+	ResolveIdentity      IdentityResolver
+	PopulateClaims       []ClaimsPopulator
+	Wif                  *wifPolicy
 }
 
 type ThOptsFunc func(*tokenHandler) error
@@ -39,10 +45,39 @@ func WithAllGroupsPopulator(p AllGroupsPopulator) ThOptsFunc {
 	}
 }
 
+// WithRateLimiter limits exchanges per namespace and service account. It is
+// applied after the subject token is validated, so callers cannot exhaust
+// the limits of others.
+func WithRateLimiter(l RateLimiter) ThOptsFunc {
+	return func(th *tokenHandler) error {
+		th.RateLimiter = l
+		return nil
+	}
+}
+
+// WithClaimsPopulator adds claims to every issued token. Several populators
+// can be added, and run in the order they were added.
+func WithClaimsPopulator(p ClaimsPopulator) ThOptsFunc {
+	return func(th *tokenHandler) error {
+		th.PopulateClaims = append(th.PopulateClaims, p)
+		return nil
+	}
+}
+
+// WithIdentityResolver sets how the identity of the subject token is found
+// from its namespace. It defaults to identity.Default.
+func WithIdentityResolver(r IdentityResolver) ThOptsFunc {
+	return func(th *tokenHandler) error {
+		th.ResolveIdentity = r
+		return nil
+	}
+}
+
 func NewTokenHandler(parser TokenParser, issuer TokenIssuer, opts ...ThOptsFunc) (*tokenHandler, error) {
 	th := &tokenHandler{
-		ParseToken:  parser,
-		TokenIssuer: issuer,
+		ParseToken:      parser,
+		TokenIssuer:     issuer,
+		ResolveIdentity: identity.Default.Resolve,
 	}
 
 	for _, opt := range opts {
@@ -57,13 +92,21 @@ func NewTokenHandler(parser TokenParser, issuer TokenIssuer, opts ...ThOptsFunc)
 type TokenParser func(ctx context.Context, rawToken string) (*KubernetesIoClaim, error)
 
 type TokenIssuer interface {
-	IssueToken(ctx context.Context, username string, audience []string, scopes []string, mappers ...Mapper) ([]byte, error)
+	IssueToken(ctx context.Context, subject string, audience []string, scopes []string, mappers ...Mapper) ([]byte, error)
 	PublicKey() (jwk.Key, error)
 }
 
+type RateLimiter interface {
+	Reserve(key string) (retryAfter time.Duration, ok bool)
+}
+
 type CurrentGroupPopulator func(ctx context.Context, serviceAccount, namespace string) Mapper
 
-type AllGroupsPopulator func(ctx context.Context, username string) Mapper
+type AllGroupsPopulator func(ctx context.Context, mc MapperContext) Mapper
+
+type ClaimsPopulator func(ctx context.Context, mc MapperContext) Mapper
+
+type IdentityResolver func(ctx context.Context, namespace, serviceAccount string) (identity.Identity, error)
 
 func (h *tokenHandler) ExchangeToken(ctx context.Context, req *api.TokenExchangeRequest) (api.ExchangeTokenRes, error) {
 	if req == nil {
@@ -94,9 +137,68 @@ func (h *tokenHandler) ExchangeToken(ctx context.Context, req *api.TokenExchange
 		return nil, err
 	}
 
-	username := strings.TrimPrefix(kubernetesClaims.Namespace, UserNamespacePrefix)
-	if username == kubernetesClaims.Namespace {
-		return nil, fmt.Errorf("invalid user namespace %q", kubernetesClaims.Namespace)
+	wif := h.Wif.applies(req.Audience)
+	if wif {
+		if err := h.Wif.checkAudience(req.Audience); err != nil {
+			return &api.ExchangeToken4XXStatusCode{
+				StatusCode: http.StatusBadRequest,
+				Response: api.ExchangeToken4XX{
+					Error:            api.ExchangeToken4XXErrorInvalidTarget,
+					ErrorDescription: api.NewOptString(err.Error()),
+				},
+			}, nil
+		}
+		// Attribute mappings need a stable dapla.group
+		if !slices.Contains(scopes, "current_group") {
+			scopes = append(scopes, "current_group")
+		}
+	}
+
+	if h.RateLimiter != nil {
+		key := kubernetesClaims.Namespace + "/" + kubernetesClaims.ServiceAccount.Name
+		if retryAfter, ok := h.RateLimiter.Reserve(key); !ok {
+			return nil, &ratelimit.LimitedError{RetryAfter: retryAfter}
+		}
+	}
+
+	id, err := h.ResolveIdentity(ctx, kubernetesClaims.Namespace, kubernetesClaims.ServiceAccount.Name)
+	if err != nil {
+		if errors.Is(err, identity.ErrUnmappedNamespace) {
+			return &api.ExchangeToken4XXStatusCode{
+				StatusCode: http.StatusBadRequest,
+				Response: api.ExchangeToken4XX{
+					Error:            api.ExchangeToken4XXErrorInvalidRequest,
+					ErrorDescription: api.NewOptString(err.Error()),
+				},
+			}, nil
+		}
+		return nil, fmt.Errorf("resolve identity: %w", err)
+	}
+
+	if wif {
+		if err := h.Wif.checkSubject(id.Subject); err != nil {
+			return &api.ExchangeToken4XXStatusCode{
+				StatusCode: http.StatusBadRequest,
+				Response: api.ExchangeToken4XX{
+					Error:            api.ExchangeToken4XXErrorInvalidRequest,
+					ErrorDescription: api.NewOptString(err.Error()),
+				},
+			}, nil
+		}
+	}
+
+	mc := MapperContext{
+		Identity: id,
+		ServiceAccount: KubernetesMeta{
+			Name:      kubernetesClaims.ServiceAccount.Name,
+			Namespace: kubernetesClaims.Namespace,
+		},
+		Pod: KubernetesMeta{
+			Name:      kubernetesClaims.Pod.Name,
+			Namespace: kubernetesClaims.Namespace,
+		},
+		Audience: req.Audience,
+		Scopes:   scopes,
 	}
 
 	var mappers []Mapper
@@ -106,10 +208,32 @@ func (h *tokenHandler) ExchangeToken(ctx context.Context, req *api.TokenExchange
 	}
 
 	if h.PopulateAllGroups != nil && slices.Contains(scopes, "all_groups") {
-		mappers = append(mappers, h.PopulateAllGroups(ctx, username))
+		if id.Kind != identity.KindUser {
+			return &api.ExchangeToken4XXStatusCode{
+				StatusCode: http.StatusBadRequest,
+				Response: api.ExchangeToken4XX{
+					Error:            api.ExchangeToken4XXErrorInvalidScope,
+					ErrorDescription: api.NewOptString("all_groups is only available to users"),
+				},
+			}, nil
+		}
+		mappers = append(mappers, h.PopulateAllGroups(ctx, mc))
 	}
 
-	issuedToken, err := h.TokenIssuer.IssueToken(ctx, username, req.Audience, scopes, mappers...)
+	for _, populate := range h.PopulateClaims {
+		mappers = append(mappers, populate(ctx, mc))
+	}
+
+	issuedToken, err := h.TokenIssuer.IssueToken(ctx, id.Subject, req.Audience, scopes, mappers...)
+	if errors.Is(err, ErrInvalidClaims) || errors.Is(err, ErrNoCurrentGroup) {
+		return &api.ExchangeToken4XXStatusCode{
+			StatusCode: http.StatusBadRequest,
+			Response: api.ExchangeToken4XX{
+				Error:            api.ExchangeToken4XXErrorInvalidRequest,
+				ErrorDescription: api.NewOptString(err.Error()),
+			},
+		}, nil
+	}
 	if err != nil {
 		slog.Error(err.Error())
 		return nil, errors.New("unexpected error issuing token")
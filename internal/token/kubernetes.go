@@ -11,6 +11,9 @@ import (
 
 var (
 	ErrInvalidToken = errors.New("invalid subject_token")
+	// ErrInvalidClaims is returned by mappers when claims requested by the
+	// caller, e.g. through annotations, are not allowed.
+	ErrInvalidClaims = errors.New("invalid claims")
 )
 
 type KubernetesIoClaim struct {
@@ -18,6 +21,10 @@ type KubernetesIoClaim struct {
 	ServiceAccount struct {
 		Name string `json:"name"`
 	} `json:"serviceaccount"`
+	Pod struct {
+		Name string `json:"name"`
+		Uid  string `json:"uid"`
+	} `json:"pod"`
 }
 
 type JwksGetter interface {
@@ -3,6 +3,7 @@ package token
 import (
 	"context"
 	"errors"
+	"fmt"
 	"strings"
 	"time"
 
@@ -39,7 +40,7 @@ func NewSignedJwtIssuer(issuer string, signingKey jwk.Key, opts ...optFunc) (*si
 	return sjc, nil
 }
 
-func (c *signedJwtIssuer) IssueToken(ctx context.Context, username string, audience []string, scopes []string, mappers ...Mapper) ([]byte, error) {
+func (c *signedJwtIssuer) IssueToken(ctx context.Context, subject string, audience []string, scopes []string, mappers ...Mapper) ([]byte, error) {
 	jwtBuilder := jwt.NewBuilder()
 
 	for _, m := range mappers {
@@ -48,7 +49,7 @@ func (c *signedJwtIssuer) IssueToken(ctx context.Context, username string, audie
 		}
 	}
 
-	jwtBuilder.Subject(username)
+	jwtBuilder.Subject(subject)
 
 	jwtBuilder.Expiration(time.Now().Add(c.Expiry))
 	jwtBuilder.IssuedAt(time.Now())
@@ -68,6 +69,27 @@ func (c *signedJwtIssuer) IssueToken(ctx context.Context, username string, audie
 	return jwt.Sign(token, jwt.WithKey(jwa.RS256(), c.SigningKey))
 }
 
+// Verify parses a token issued by c, validating its signature, issuer and
+// expiry.
+func (c *signedJwtIssuer) Verify(rawToken string) (jwt.Token, error) {
+	publicKey, err := c.SigningKey.PublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("get public key: %w", err)
+	}
+	opts := []jwt.ParseOption{
+		jwt.WithKey(jwa.RS256(), publicKey),
+		jwt.WithValidate(true),
+	}
+	if c.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(c.Issuer))
+	}
+	token, err := jwt.Parse([]byte(rawToken), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("parse and validate token: %w", err)
+	}
+	return token, nil
+}
+
 func (c *signedJwtIssuer) PublicKey() (jwk.Key, error) {
 	return c.SigningKey.PublicKey()
 }
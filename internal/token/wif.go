@@ -0,0 +1,79 @@
+package token
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// MaxWifSubjectLength is the longest sub Google Cloud STS accepts, since it
+// is usually mapped to google.subject.
+const MaxWifSubjectLength = 127
+
+var wifAudience = regexp.MustCompile(`^(?:https:)?//iam\.googleapis\.com/projects/[0-9]+/locations/global/workloadIdentityPools/[a-z0-9-]+/providers/[a-z0-9-]+$`)
+
+// IsWifAudience reports whether aud is meant for Google Cloud Workload
+// Identity Federation, i.e. is on iam.googleapis.com.
+func IsWifAudience(aud string) bool {
+	return strings.HasPrefix(aud, "//iam.googleapis.com/") || strings.HasPrefix(aud, "https://iam.googleapis.com/")
+}
+
+// wifPolicy checks exchanges for Workload Identity Federation audiences.
+type wifPolicy struct {
+	// allowedProviders are the allowed audiences, any provider if empty
+	allowedProviders []string
+}
+
+// WithWorkloadIdentityFederation issues tokens for audiences on
+// iam.googleapis.com in a shape Google Cloud STS accepts: a single audience
+// naming a workload identity pool provider, a sub of at most
+// MaxWifSubjectLength bytes, and always the dapla.group claim so it can be
+// used in attribute mappings. allowedProviders limits the providers tokens
+// are issued for, either audience form is accepted for each. Without this
+// option, iam.googleapis.com audiences are treated as any other.
+func WithWorkloadIdentityFederation(allowedProviders []string) ThOptsFunc {
+	return func(th *tokenHandler) error {
+		for _, p := range allowedProviders {
+			if !wifAudience.MatchString(p) {
+				return fmt.Errorf("%q is not a workload identity pool provider", p)
+			}
+		}
+		th.Wif = &wifPolicy{allowedProviders: allowedProviders}
+		return nil
+	}
+}
+
+// applies reports whether the exchange is for Workload Identity Federation.
+func (p *wifPolicy) applies(audience []string) bool {
+	return p != nil && slices.ContainsFunc(audience, IsWifAudience)
+}
+
+// checkAudience returns why audience cannot be used for a federated token.
+func (p *wifPolicy) checkAudience(audience []string) error {
+	if len(audience) != 1 {
+		return fmt.Errorf("workload identity federation tokens must have exactly one audience, got %d", len(audience))
+	}
+	aud := audience[0]
+	if !wifAudience.MatchString(aud) {
+		return fmt.Errorf("audience %q is not a workload identity pool provider", aud)
+	}
+	if len(p.allowedProviders) == 0 {
+		return nil
+	}
+	name := strings.TrimPrefix(aud, "https:")
+	for _, allowed := range p.allowedProviders {
+		if strings.TrimPrefix(allowed, "https:") == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("workload identity pool provider %q is not allowed", aud)
+}
+
+// checkSubject returns why subject cannot be used for a federated token.
+func (p *wifPolicy) checkSubject(subject string) error {
+	if len(subject) > MaxWifSubjectLength {
+		return fmt.Errorf("subject is %d bytes, workload identity federation allows at most %d", len(subject), MaxWifSubjectLength)
+	}
+	return nil
+}
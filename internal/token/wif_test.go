@@ -0,0 +1,219 @@
+package token_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"cel.dev/cel-go/cel"
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jws"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+	api "github.com/statisticsnorway/labid/api/oas"
+	"github.com/statisticsnorway/labid/internal/token"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const wifAudience = "//iam.googleapis.com/projects/123456789012/locations/global/workloadIdentityPools/dapla-lab/providers/labid"
+
+func readFixture(t *testing.T, name string, v any) {
+	t.Helper()
+	data, err := os.ReadFile("testdata/wif/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+}
+
+func wifIssuer(t *testing.T) token.TokenIssuer {
+	t.Helper()
+	issuer, err := token.NewSignedJwtIssuer("https://labid.lab.dapla.ssb.no", SigningKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return issuer
+}
+
+func wifHandler(t *testing.T, issuer token.TokenIssuer, namespace string, allowedProviders ...string) api.Handler {
+	t.Helper()
+	parse := func(context.Context, string) (*token.KubernetesIoClaim, error) {
+		var c token.KubernetesIoClaim
+		c.Namespace = namespace
+		c.ServiceAccount.Name = "default"
+		return &c, nil
+	}
+	currentGroup := func(context.Context, string, string) token.Mapper {
+		return func(_ context.Context, b *jwt.Builder) error {
+			b.Claim("dapla.group", "dapla-felles-developers")
+			return nil
+		}
+	}
+	h, err := token.NewTokenHandler(parse, issuer,
+		token.WithCurrentGroupPopulator(currentGroup),
+		token.WithWorkloadIdentityFederation(allowedProviders),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+// TestWifTokenShape verifies a token issued for Workload Identity Federation
+// against the fixtures in testdata/wif, which document what Google Cloud STS
+// gets: the claims, and the attributes from an example attribute mapping.
+func TestWifTokenShape(t *testing.T) {
+	issuer := wifIssuer(t)
+	h := wifHandler(t, issuer, "user-ssb-kari")
+	res, err := h.ExchangeToken(context.Background(), &api.TokenExchangeRequest{
+		SubjectToken: "kubernetes-token",
+		Audience:     []string{wifAudience},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, isOk := res.(*api.ExchangeTokenOK)
+	if !isOk {
+		t.Fatalf("expected token, got %#v", res)
+	}
+
+	pub, err := issuer.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := jws.Parse([]byte(ok.AccessToken))
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := msg.Signatures()[0].ProtectedHeaders()
+	if alg, _ := header.Algorithm(); alg != jwa.RS256() {
+		t.Errorf("expected RS256, got %v", alg)
+	}
+	if kid, _ := header.KeyID(); kid == "" {
+		t.Error("expected kid in header, STS picks the key by kid")
+	}
+
+	tok, err := jwt.Parse([]byte(ok.AccessToken), jwt.WithKey(jwa.RS256(), pub), jwt.WithAudience(wifAudience))
+	if err != nil {
+		t.Fatal(err)
+	}
+	iat, _ := tok.IssuedAt()
+	exp, _ := tok.Expiration()
+	if lifetime := exp.Sub(iat); lifetime <= 0 || lifetime > 24*time.Hour {
+		t.Errorf("STS rejects tokens valid for more than 24 hours, got %s", lifetime)
+	}
+
+	var payload map[string]any
+	if err := json.Unmarshal(msg.Payload(), &payload); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []string{"iat", "exp"} {
+		if _, ok := payload[c]; !ok {
+			t.Errorf("expected claim %q", c)
+		}
+		delete(payload, c)
+	}
+	var wantClaims map[string]any
+	readFixture(t, "claims.json", &wantClaims)
+	if !reflect.DeepEqual(payload, wantClaims) {
+		t.Errorf("expected claims %v, got %v", wantClaims, payload)
+	}
+
+	var mapping, wantAttributes map[string]string
+	readFixture(t, "attribute-mapping.json", &mapping)
+	readFixture(t, "attributes.json", &wantAttributes)
+	env, err := cel.NewEnv(cel.Variable("assertion", cel.MapType(cel.StringType, cel.DynType)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	attributes := map[string]string{}
+	for name, expr := range mapping {
+		ast, issues := env.Compile(expr)
+		if issues.Err() != nil {
+			t.Fatalf("%s: %v", name, issues.Err())
+		}
+		program, err := env.Program(ast)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, _, err := program.Eval(map[string]any{"assertion": payload})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		attributes[name], _ = out.Value().(string)
+	}
+	if !reflect.DeepEqual(attributes, wantAttributes) {
+		t.Errorf("expected attributes %v, got %v", wantAttributes, attributes)
+	}
+	if len(attributes["google.subject"]) > token.MaxWifSubjectLength {
+		t.Errorf("google.subject is longer than %d bytes", token.MaxWifSubjectLength)
+	}
+}
+
+func TestWifRejectedExchanges(t *testing.T) {
+	issuer := wifIssuer(t)
+	allowed := "https://iam.googleapis.com/projects/123456789012/locations/global/workloadIdentityPools/dapla-lab/providers/labid"
+	for name, tc := range map[string]struct {
+		namespace string
+		audience  []string
+		error     api.ExchangeToken4XXError
+	}{
+		"several audiences": {"user-ssb-kari", []string{wifAudience, "other"}, api.ExchangeToken4XXErrorInvalidTarget},
+		"not a provider":    {"user-ssb-kari", []string{"//iam.googleapis.com/projects/dapla"}, api.ExchangeToken4XXErrorInvalidTarget},
+		"not allowed": {"user-ssb-kari", []string{
+			"//iam.googleapis.com/projects/123456789012/locations/global/workloadIdentityPools/other/providers/labid",
+		}, api.ExchangeToken4XXErrorInvalidTarget},
+		"long subject": {"user-ssb-" + strings.Repeat("a", 128), []string{wifAudience}, api.ExchangeToken4XXErrorInvalidRequest},
+	} {
+		h := wifHandler(t, issuer, tc.namespace, allowed)
+		res, err := h.ExchangeToken(context.Background(), &api.TokenExchangeRequest{
+			SubjectToken: "kubernetes-token",
+			Audience:     tc.audience,
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		errRes, ok := res.(*api.ExchangeToken4XXStatusCode)
+		if !ok || errRes.StatusCode != http.StatusBadRequest || errRes.Response.Error != tc.error {
+			t.Errorf("%s: expected %s, got %#v", name, tc.error, res)
+		}
+	}
+}
+
+func TestWifWithoutCurrentGroup(t *testing.T) {
+	parse := func(context.Context, string) (*token.KubernetesIoClaim, error) {
+		var c token.KubernetesIoClaim
+		c.Namespace = "user-ssb-kari"
+		c.ServiceAccount.Name = "default"
+		return &c, nil
+	}
+	getSa := func(_ context.Context, name, namespace string) (*corev1.ServiceAccount, error) {
+		return &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}, nil
+	}
+	h, err := token.NewTokenHandler(parse, wifIssuer(t),
+		token.WithCurrentGroupPopulator(token.CurrentGroupMapper(context.Background(), getSa)),
+		token.WithWorkloadIdentityFederation(nil),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := h.ExchangeToken(context.Background(), &api.TokenExchangeRequest{
+		SubjectToken: "kubernetes-token",
+		Audience:     []string{wifAudience},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	errRes, ok := res.(*api.ExchangeToken4XXStatusCode)
+	if !ok || errRes.StatusCode != http.StatusBadRequest || errRes.Response.Error != api.ExchangeToken4XXErrorInvalidRequest {
+		t.Fatalf("expected invalid_request, got %#v", res)
+	}
+}
@@ -0,0 +1,101 @@
+package upstream
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+var (
+	ErrCircuitOpen = errors.New("circuit breaker open")
+)
+
+type breakerState int
+
+const (
+	closed breakerState = iota
+	open
+	halfOpen
+)
+
+// Breaker is a circuit breaker for a single upstream. After failureThreshold
+// consecutive failures it opens and rejects calls for cooldown, after which a
+// single trial call is let through. If it succeeds the breaker closes again,
+// otherwise it stays open for another cooldown.
+type Breaker struct {
+	name             string
+	failureThreshold int
+	cooldown         time.Duration
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+}
+
+func NewBreaker(name string, failureThreshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{
+		name:             name,
+		failureThreshold: failureThreshold,
+		cooldown:         cooldown,
+	}
+}
+
+// Allow returns ErrCircuitOpen if calls to the upstream should not be made.
+// Every allowed call must be followed by Success, Failure or Release.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case open:
+		if time.Since(b.openedAt) < b.cooldown {
+			return ErrCircuitOpen
+		}
+		b.state = halfOpen
+		return nil
+	case halfOpen:
+		// A trial call is already in flight
+		return ErrCircuitOpen
+	default:
+		return nil
+	}
+}
+
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != closed {
+		slog.Info("circuit breaker closed", "upstream", b.name)
+	}
+	b.state = closed
+	b.failures = 0
+}
+
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.state == halfOpen || b.failures >= b.failureThreshold {
+		if b.state != open {
+			slog.Warn("circuit breaker opened", "upstream", b.name, "failures", b.failures)
+		}
+		b.state = open
+		b.openedAt = time.Now()
+	}
+}
+
+// Release ends an allowed call which neither succeeded nor failed, e.g. since
+// it was cancelled, leaving the breaker as it was. A released trial call
+// lets the next call through as a new trial.
+func (b *Breaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == halfOpen {
+		b.state = open
+	}
+}
@@ -0,0 +1,50 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Call calls fn through breaker, retrying it with backoff while it fails with
+// an error wrapping transient. Only such errors count as failures of the
+// breaker, and only a nil error or one wrapping any of definitive, a
+// definite answer from the upstream, as successes. Other errors, such as
+// cancellation of ctx, leave the breaker as it was. While the breaker is
+// open, an error wrapping both transient and ErrCircuitOpen is returned
+// without calling fn.
+func Call[T any](ctx context.Context, backoff Backoff, breaker *Breaker, transient error, definitive []error, fn func(context.Context) (T, error)) (T, error) {
+	var res T
+	if err := breaker.Allow(); err != nil {
+		return res, fmt.Errorf("%s: %w: %w", breaker.name, transient, err)
+	}
+
+	isTransient := func(err error) bool {
+		return errors.Is(err, transient)
+	}
+	err := backoff.Retry(ctx, isTransient, func(ctx context.Context) error {
+		var err error
+		res, err = fn(ctx)
+		return err
+	})
+	switch {
+	case err == nil || isAny(err, definitive):
+		breaker.Success()
+	case ctx.Err() != nil:
+		breaker.Release()
+	case isTransient(err):
+		breaker.Failure()
+	default:
+		breaker.Release()
+	}
+	return res, err
+}
+
+func isAny(err error, targets []error) bool {
+	for _, target := range targets {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
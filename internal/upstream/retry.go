@@ -0,0 +1,55 @@
+package upstream
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// Backoff retries idempotent calls with exponential backoff and full jitter.
+type Backoff struct {
+	// Total number of attempts, including the first
+	Attempts  int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+var DefaultBackoff = Backoff{
+	Attempts:  3,
+	BaseDelay: 100 * time.Millisecond,
+	MaxDelay:  2 * time.Second,
+}
+
+// Retry calls fn until it succeeds, returns an error for which retryable is
+// false, the attempts are used up or ctx is done. The last error is returned.
+func (b Backoff) Retry(ctx context.Context, retryable func(error) bool, fn func(context.Context) error) error {
+	var err error
+	for attempt := range max(b.Attempts, 1) {
+		if attempt > 0 {
+			timer := time.NewTimer(b.delay(attempt))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+		}
+
+		err = fn(ctx)
+		if err == nil || !retryable(err) || ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func (b Backoff) delay(attempt int) time.Duration {
+	ceiling := b.BaseDelay << (attempt - 1)
+	if ceiling > b.MaxDelay || ceiling <= 0 {
+		ceiling = b.MaxDelay
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return rand.N(ceiling)
+}
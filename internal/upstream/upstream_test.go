@@ -0,0 +1,141 @@
+package upstream_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/statisticsnorway/labid/internal/upstream"
+)
+
+var errTransient = errors.New("transient")
+
+func TestBreakerOpensAndRecovers(t *testing.T) {
+	b := upstream.NewBreaker("test", 2, 20*time.Millisecond)
+
+	for range 2 {
+		if err := b.Allow(); err != nil {
+			t.Fatalf("closed breaker rejected call: %v", err)
+		}
+		b.Failure()
+	}
+	if err := b.Allow(); !errors.Is(err, upstream.ErrCircuitOpen) {
+		t.Fatalf("expected open breaker, got %v", err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("expected trial call after cooldown, got %v", err)
+	}
+	if err := b.Allow(); !errors.Is(err, upstream.ErrCircuitOpen) {
+		t.Fatalf("expected only one trial call, got %v", err)
+	}
+	b.Success()
+	if err := b.Allow(); err != nil {
+		t.Fatalf("expected closed breaker after successful trial, got %v", err)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	backoff := upstream.Backoff{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	retryable := func(err error) bool { return errors.Is(err, errTransient) }
+
+	var calls int
+	err := backoff.Retry(context.Background(), retryable, func(context.Context) error {
+		calls++
+		if calls < 3 {
+			return errTransient
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Fatalf("expected success on third attempt, err=%v, calls=%d", err, calls)
+	}
+
+	calls = 0
+	permanent := errors.New("permanent")
+	err = backoff.Retry(context.Background(), retryable, func(context.Context) error {
+		calls++
+		return permanent
+	})
+	if !errors.Is(err, permanent) || calls != 1 {
+		t.Fatalf("expected no retries of permanent error, err=%v, calls=%d", err, calls)
+	}
+
+	calls = 0
+	err = backoff.Retry(context.Background(), retryable, func(context.Context) error {
+		calls++
+		return errTransient
+	})
+	if !errors.Is(err, errTransient) || calls != 3 {
+		t.Fatalf("expected attempts to be bounded, err=%v, calls=%d", err, calls)
+	}
+}
+
+func TestCall(t *testing.T) {
+	backoff := upstream.Backoff{Attempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	breaker := upstream.NewBreaker("test", 2, time.Minute)
+
+	var calls int
+	permanent := errors.New("permanent")
+	for range 3 {
+		_, err := upstream.Call(context.Background(), backoff, breaker, errTransient, nil, func(context.Context) (string, error) {
+			calls++
+			return "", permanent
+		})
+		if !errors.Is(err, permanent) {
+			t.Fatalf("expected permanent error, got %v", err)
+		}
+	}
+	if calls != 3 {
+		t.Fatalf("expected permanent errors not to be retried or open the breaker, calls=%d", calls)
+	}
+
+	calls = 0
+	for range 2 {
+		upstream.Call(context.Background(), backoff, breaker, errTransient, nil, func(context.Context) (string, error) {
+			calls++
+			return "", errTransient
+		})
+	}
+	if calls != 4 {
+		t.Fatalf("expected transient errors to be retried, calls=%d", calls)
+	}
+	_, err := upstream.Call(context.Background(), backoff, breaker, errTransient, nil, func(context.Context) (string, error) {
+		calls++
+		return "ok", nil
+	})
+	if !errors.Is(err, upstream.ErrCircuitOpen) || !errors.Is(err, errTransient) || calls != 4 {
+		t.Fatalf("expected open breaker to reject the call as transient, err=%v, calls=%d", err, calls)
+	}
+}
+
+func TestCallCancelledTrialLeavesBreakerOpen(t *testing.T) {
+	backoff := upstream.Backoff{Attempts: 1}
+	breaker := upstream.NewBreaker("test", 2, 50*time.Millisecond)
+	fail := func(context.Context) (string, error) { return "", errTransient }
+	for range 2 {
+		upstream.Call(context.Background(), backoff, breaker, errTransient, nil, fail)
+	}
+	time.Sleep(60 * time.Millisecond)
+
+	// The half-open trial is cancelled, which is not a success
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	upstream.Call(ctx, backoff, breaker, errTransient, nil, func(ctx context.Context) (string, error) {
+		return "", ctx.Err()
+	})
+	// The next call is let through as a new trial, and a single failure
+	// opens the breaker again, as it was not closed by the cancelled trial
+	upstream.Call(context.Background(), backoff, breaker, errTransient, nil, fail)
+
+	var calls int
+	_, err := upstream.Call(context.Background(), backoff, breaker, errTransient, nil, func(context.Context) (string, error) {
+		calls++
+		return "ok", nil
+	})
+	if !errors.Is(err, upstream.ErrCircuitOpen) || calls != 0 {
+		t.Fatalf("expected the breaker to stay open, err=%v, calls=%d", err, calls)
+	}
+}
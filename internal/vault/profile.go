@@ -0,0 +1,138 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/lestrrat-go/jwx/v3/jwt"
+	"github.com/statisticsnorway/labid/internal/groups"
+	"github.com/statisticsnorway/labid/internal/identity"
+	"github.com/statisticsnorway/labid/internal/token"
+)
+
+// Claims set on tokens for Vault.
+const (
+	GroupClaim          = "group"
+	GroupsClaim         = "groups"
+	NamespaceClaim      = "namespace"
+	ServiceAccountClaim = "service_account"
+	RoleClaim           = "vault_role"
+)
+
+// Claims are the names of all claims set on tokens for Vault.
+var Claims = []string{GroupClaim, GroupsClaim, NamespaceClaim, ServiceAccountClaim, RoleClaim}
+
+// Profile adds flat claims for the bound_claims, user_claim and groups_claim
+// of HashiCorp Vault JWT auth roles to tokens requested for one of the
+// audiences of Vault, i.e. the bound_audiences of its roles:
+//
+//   - group: the current group, if the service account has one
+//   - groups: all groups of the user with the all_groups scope, unless
+//     there are more than the overage threshold, otherwise the current
+//     group, always a list so groups_claim can be used
+//   - namespace and service_account: of the subject token
+//   - vault_role: the role template executed for the current group, if set
+type Profile struct {
+	audiences []string
+	role      *template.Template
+}
+
+// NewProfile creates a profile for tokens with one of audiences. The role
+// template gets the fields group, namespace and serviceAccount, e.g.
+// {{.group}} or labid-{{.namespace}}. An empty template sets no vault_role.
+func NewProfile(audiences []string, roleTemplate string) (*Profile, error) {
+	if len(audiences) == 0 {
+		return nil, errors.New("vault profile requires at least one audience")
+	}
+	p := &Profile{audiences: audiences}
+	if roleTemplate != "" {
+		role, err := template.New("vault_role").Option("missingkey=error").Parse(roleTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("parse vault role template: %w", err)
+		}
+		sample := map[string]string{"group": "dapla-felles-developers", "namespace": "user-ssb-kari", "serviceAccount": "default"}
+		if err := role.Execute(&strings.Builder{}, sample); err != nil {
+			return nil, fmt.Errorf("execute vault role template: %w", err)
+		}
+		p.role = role
+	}
+	return p, nil
+}
+
+func (p *Profile) applies(audience []string) bool {
+	return slices.ContainsFunc(audience, func(aud string) bool {
+		return slices.Contains(p.audiences, aud)
+	})
+}
+
+// Sources is where the groups of tokens for Vault are found.
+type Sources struct {
+	GetServiceAccount token.ServiceAccountGetter
+	// Groups may be nil, in which case groups only has the current group
+	Groups groups.Provider
+	// Filters may be nil, otherwise groups are filtered as dapla.groups
+	Filters *groups.Filters
+	// OverageThreshold is the groups.WithOverage threshold. Above it, groups
+	// only has the current group, as for tokens without the all_groups
+	// scope. 0 disables it.
+	OverageThreshold int
+}
+
+// Populator sets the claims of p on tokens for Vault.
+func Populator(p *Profile, sources Sources) token.ClaimsPopulator {
+	return func(_ context.Context, mc token.MapperContext) token.Mapper {
+		return func(ctx context.Context, builder *jwt.Builder) error {
+			if !p.applies(mc.Audience) {
+				return nil
+			}
+			sa, err := sources.GetServiceAccount(ctx, mc.ServiceAccount.Name, mc.ServiceAccount.Namespace)
+			if err != nil {
+				return fmt.Errorf("get service account: %w", err)
+			}
+			group := sa.Annotations[token.DaplaGroupAnnotation]
+
+			userGroups := []string{}
+			if sources.Groups != nil && mc.Identity.Kind == identity.KindUser && slices.Contains(mc.Scopes, "all_groups") {
+				all, err := sources.Groups.ListGroups(ctx, mc.Identity.Email)
+				if err != nil {
+					return err
+				}
+				if sources.Filters != nil {
+					all = sources.Filters.Apply(mc.Audience, all)
+				}
+				if sources.OverageThreshold == 0 || len(all) <= sources.OverageThreshold {
+					userGroups = append(userGroups, all...)
+				}
+			}
+			if len(userGroups) == 0 && group != "" {
+				userGroups = append(userGroups, group)
+			}
+
+			builder.Claim(GroupsClaim, userGroups)
+			builder.Claim(NamespaceClaim, mc.ServiceAccount.Namespace)
+			builder.Claim(ServiceAccountClaim, mc.ServiceAccount.Name)
+			if group == "" {
+				return nil
+			}
+			builder.Claim(GroupClaim, group)
+
+			if p.role != nil {
+				var role strings.Builder
+				err := p.role.Execute(&role, map[string]string{
+					"group":          group,
+					"namespace":      mc.ServiceAccount.Namespace,
+					"serviceAccount": mc.ServiceAccount.Name,
+				})
+				if err != nil {
+					return fmt.Errorf("execute vault role template: %w", err)
+				}
+				builder.Claim(RoleClaim, role.String())
+			}
+			return nil
+		}
+	}
+}
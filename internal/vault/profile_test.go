@@ -0,0 +1,279 @@
+package vault_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jws"
+	"github.com/statisticsnorway/labid/internal/groups"
+	"github.com/statisticsnorway/labid/internal/identity"
+	"github.com/statisticsnorway/labid/internal/token"
+	"github.com/statisticsnorway/labid/internal/vault"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// role is a model of a role of the Vault JWT auth method, with how Vault
+// validates the claims of a token when logging in.
+type role struct {
+	BoundAudiences  []string       `json:"bound_audiences"`
+	BoundSubject    string         `json:"bound_subject"`
+	BoundClaimsType string         `json:"bound_claims_type"`
+	BoundClaims     map[string]any `json:"bound_claims"`
+	UserClaim       string         `json:"user_claim"`
+	GroupsClaim     string         `json:"groups_claim"`
+}
+
+// claim gets a claim by name, or by JSON pointer if it starts with /.
+func claim(claims map[string]any, name string) any {
+	if !strings.HasPrefix(name, "/") {
+		return claims[name]
+	}
+	var v any = claims
+	for part := range strings.SplitSeq(strings.TrimPrefix(name, "/"), "/") {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil
+		}
+		v = m[part]
+	}
+	return v
+}
+
+// stringList is a claim as a list, since Vault treats a string as a list of one.
+func stringList(v any) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		var s []string
+		for _, item := range v {
+			if str, ok := item.(string); ok {
+				s = append(s, str)
+			}
+		}
+		return s
+	}
+	return nil
+}
+
+// login returns the alias name and group aliases Vault would create for a
+// token with claims, or why it would reject the token.
+func (r role) login(claims map[string]any) (string, []string, error) {
+	if len(r.BoundAudiences) > 0 {
+		if !slices.ContainsFunc(stringList(claims["aud"]), func(aud string) bool {
+			return slices.Contains(r.BoundAudiences, aud)
+		}) {
+			return "", nil, fmt.Errorf("aud claim does not match any bound audience")
+		}
+	}
+	if r.BoundSubject != "" && claims["sub"] != r.BoundSubject {
+		return "", nil, fmt.Errorf("sub claim does not match bound subject")
+	}
+	for name, expected := range r.BoundClaims {
+		actual := claim(claims, name)
+		if actual == nil {
+			return "", nil, fmt.Errorf("claim %q is missing", name)
+		}
+		matches := slices.ContainsFunc(stringList(actual), func(a string) bool {
+			return slices.ContainsFunc(stringList(expected), func(e string) bool {
+				if r.BoundClaimsType == "glob" {
+					ok, _ := path.Match(e, a)
+					return ok
+				}
+				return a == e
+			})
+		})
+		if !matches {
+			return "", nil, fmt.Errorf("claim %q does not match any associated bound claim values", name)
+		}
+	}
+	user, ok := claim(claims, r.UserClaim).(string)
+	if !ok {
+		return "", nil, fmt.Errorf("claim %q not found in token", r.UserClaim)
+	}
+	var groupAliases []string
+	if r.GroupsClaim != "" {
+		v := claim(claims, r.GroupsClaim)
+		if v == nil {
+			return "", nil, fmt.Errorf("%q claim not found in token", r.GroupsClaim)
+		}
+		groupAliases = stringList(v)
+	}
+	return user, groupAliases, nil
+}
+
+const vaultAudience = "https://vault.dapla.ssb.no"
+
+func issue(t *testing.T, profile *vault.Profile, audience []string, scopes []string, opts ...func(*vault.Sources)) map[string]any {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingKey, err := jwk.Import(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, err := token.NewSignedJwtIssuer("https://labid.lab.dapla.ssb.no", signingKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sources := vault.Sources{
+		GetServiceAccount: func(_ context.Context, name, namespace string) (*corev1.ServiceAccount, error) {
+			return &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   namespace,
+				Annotations: map[string]string{token.DaplaGroupAnnotation: "dapla-felles-developers"},
+			}}, nil
+		},
+		Groups: groups.ProviderFunc(func(context.Context, string) ([]string, error) {
+			return []string{"dapla-felles-developers", "play-foeniks-developers"}, nil
+		}),
+	}
+	for _, opt := range opts {
+		opt(&sources)
+	}
+	populate := vault.Populator(profile, sources)
+	mc := token.MapperContext{
+		Identity:       identity.Identity{Kind: identity.KindUser, Subject: "kari", Email: "kari@ssb.no"},
+		ServiceAccount: token.KubernetesMeta{Name: "default", Namespace: "user-ssb-kari"},
+		Audience:       audience,
+		Scopes:         scopes,
+	}
+	signed, err := issuer.IssueToken(context.Background(), mc.Identity.Subject, audience, scopes, populate(context.Background(), mc))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pub, err := signingKey.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := jws.Verify(signed, jws.WithKey(jwa.RS256(), pub))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var claims map[string]any
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		t.Fatal(err)
+	}
+	return claims
+}
+
+func TestVaultLogin(t *testing.T) {
+	profile, err := vault.NewProfile([]string{vaultAudience}, "{{.group}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tc := range map[string]struct {
+		role     role
+		audience []string
+		scopes   []string
+		user     string
+		groups   []string
+	}{
+		"bound group": {
+			role: role{
+				BoundAudiences: []string{vaultAudience},
+				BoundClaims:    map[string]any{"group": []any{"dapla-felles-developers", "dapla-felles-managers"}},
+				UserClaim:      "sub",
+				GroupsClaim:    "groups",
+			},
+			audience: []string{vaultAudience},
+			user:     "kari",
+			groups:   []string{"dapla-felles-developers"},
+		},
+		"role hint": {
+			role: role{
+				BoundAudiences: []string{vaultAudience},
+				BoundClaims:    map[string]any{"vault_role": "dapla-felles-developers"},
+				UserClaim:      "service_account",
+			},
+			audience: []string{vaultAudience, "other"},
+			user:     "default",
+		},
+		"glob namespace and all groups": {
+			role: role{
+				BoundAudiences:  []string{vaultAudience},
+				BoundClaimsType: "glob",
+				BoundClaims:     map[string]any{"namespace": "user-ssb-*", "groups": "play-*"},
+				UserClaim:       "sub",
+				GroupsClaim:     "groups",
+			},
+			audience: []string{vaultAudience},
+			scopes:   []string{"all_groups"},
+			user:     "kari",
+			groups:   []string{"dapla-felles-developers", "play-foeniks-developers"},
+		},
+	} {
+		claims := issue(t, profile, tc.audience, tc.scopes)
+		user, groupAliases, err := tc.role.login(claims)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if user != tc.user || !reflect.DeepEqual(groupAliases, tc.groups) {
+			t.Errorf("%s: expected %q with groups %v, got %q with %v", name, tc.user, tc.groups, user, groupAliases)
+		}
+	}
+}
+
+func TestVaultGroupsOverage(t *testing.T) {
+	profile, err := vault.NewProfile([]string{vaultAudience}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := issue(t, profile, []string{vaultAudience}, []string{"all_groups"}, func(s *vault.Sources) {
+		s.OverageThreshold = 1
+	})
+	if got := stringList(claims["groups"]); !reflect.DeepEqual(got, []string{"dapla-felles-developers"}) {
+		t.Fatalf("expected only the current group on overage, got %v", got)
+	}
+}
+
+func TestVaultLoginRejected(t *testing.T) {
+	profile, err := vault.NewProfile([]string{vaultAudience}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := role{
+		BoundAudiences: []string{vaultAudience, "other"},
+		BoundClaims:    map[string]any{"group": "dapla-felles-developers"},
+		UserClaim:      "sub",
+	}
+
+	// Tokens for other audiences do not get the claims
+	if _, _, err := r.login(issue(t, profile, []string{"other"}, nil)); err == nil {
+		t.Error("expected login without group claim to fail")
+	}
+	r.BoundClaims = map[string]any{"group": "dapla-felles-managers"}
+	if _, _, err := r.login(issue(t, profile, []string{vaultAudience}, nil)); err == nil {
+		t.Error("expected login with other group to fail")
+	}
+	r.BoundClaims = map[string]any{"vault_role": "dapla-felles-developers"}
+	if _, _, err := r.login(issue(t, profile, []string{vaultAudience}, nil)); err == nil {
+		t.Error("expected login without vault_role to fail")
+	}
+}
+
+func TestNewProfileErrors(t *testing.T) {
+	if _, err := vault.NewProfile(nil, ""); err == nil {
+		t.Error("expected error without audiences")
+	}
+	if _, err := vault.NewProfile([]string{vaultAudience}, "{{.team}}"); err == nil {
+		t.Error("expected error for unknown template field")
+	}
+}
@@ -0,0 +1,210 @@
+// Package client exchanges the Kubernetes service account token of a pod for
+// LabID tokens.
+//
+//	ts := client.NewTokenSource("http://labid.labid.svc.cluster.local",
+//		client.WithScopes("current_group"),
+//		client.WithAudience("my-service"),
+//	)
+//	httpClient := oauth2.NewClient(ctx, ts)
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// DefaultServiceAccountTokenFile is where Kubernetes mounts the service
+// account token of a pod.
+const DefaultServiceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+const (
+	grantType        = "urn:ietf:params:oauth:grant-type:token-exchange"
+	subjectTokenType = "urn:ietf:params:oauth:grant-type:id_token"
+)
+
+type tokenSource struct {
+	tokenUrl      string
+	scopes        []string
+	audiences     []string
+	saTokenFile   string
+	refreshBefore time.Duration
+	timeout       time.Duration
+	httpClient    *http.Client
+	now           func() time.Time
+
+	mu    sync.Mutex
+	token *oauth2.Token
+}
+
+type Option func(*tokenSource)
+
+// WithScopes requests tokens with scopes, e.g. current_group and all_groups.
+func WithScopes(scopes ...string) Option {
+	return func(ts *tokenSource) {
+		ts.scopes = append(ts.scopes, scopes...)
+	}
+}
+
+// WithAudience requests tokens for audiences.
+func WithAudience(audiences ...string) Option {
+	return func(ts *tokenSource) {
+		ts.audiences = append(ts.audiences, audiences...)
+	}
+}
+
+// WithServiceAccountTokenFile reads the subject token from path instead of
+// DefaultServiceAccountTokenFile, e.g. a projected token with LabID as its
+// audience.
+func WithServiceAccountTokenFile(path string) Option {
+	return func(ts *tokenSource) {
+		ts.saTokenFile = path
+	}
+}
+
+// WithRefreshBefore sets how long before expiry tokens are refreshed. It
+// defaults to 5 minutes.
+func WithRefreshBefore(d time.Duration) Option {
+	return func(ts *tokenSource) {
+		ts.refreshBefore = d
+	}
+}
+
+// WithTimeout bounds how long an exchange may take, as callers of Token wait
+// for it. It defaults to 10 seconds.
+func WithTimeout(d time.Duration) Option {
+	return func(ts *tokenSource) {
+		ts.timeout = d
+	}
+}
+
+// WithHTTPClient sets the client for calling LabID.
+func WithHTTPClient(c *http.Client) Option {
+	return func(ts *tokenSource) {
+		ts.httpClient = c
+	}
+}
+
+// NewTokenSource returns a token source exchanging the service account token
+// at the /token endpoint of labidUrl. Tokens are cached until refreshBefore
+// their expiry. The service account token is read for every exchange, so
+// rotated tokens are picked up.
+func NewTokenSource(labidUrl string, opts ...Option) oauth2.TokenSource {
+	ts := &tokenSource{
+		tokenUrl:      strings.TrimSuffix(labidUrl, "/") + "/token",
+		saTokenFile:   DefaultServiceAccountTokenFile,
+		refreshBefore: 5 * time.Minute,
+		timeout:       10 * time.Second,
+		httpClient:    http.DefaultClient,
+		now:           time.Now,
+	}
+	for _, opt := range opts {
+		opt(ts)
+	}
+	return ts
+}
+
+// Token returns the cached token, or exchanges a new one if it is about to
+// expire. Failed exchanges are returned as *oauth2.RetrieveError if LabID
+// responded.
+func (ts *tokenSource) Token() (*oauth2.Token, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.token != nil && ts.now().Add(ts.refreshBefore).Before(ts.token.Expiry) {
+		return ts.token, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ts.timeout)
+	defer cancel()
+	token, err := ts.exchange(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ts.token = token
+	return token, nil
+}
+
+type tokenResponse struct {
+	AccessToken     string  `json:"access_token"`
+	IssuedTokenType string  `json:"issued_token_type"`
+	TokenType       string  `json:"token_type"`
+	ExpiresIn       float64 `json:"expires_in"`
+}
+
+type errorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func (ts *tokenSource) exchange(ctx context.Context) (*oauth2.Token, error) {
+	saToken, err := os.ReadFile(ts.saTokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("read service account token: %w", err)
+	}
+
+	form := url.Values{
+		"grant_type":         {grantType},
+		"subject_token_type": {subjectTokenType},
+		"subject_token":      {strings.TrimSpace(string(saToken))},
+	}
+	if len(ts.scopes) > 0 {
+		form.Set("scope", strings.Join(ts.scopes, ","))
+	}
+	for _, aud := range ts.audiences {
+		form.Add("audience", aud)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ts.tokenUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	issuedAt := ts.now()
+	res, err := ts.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("exchange token: %w", err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("read token response: %w", err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		retrieveErr := &oauth2.RetrieveError{Response: res, Body: body}
+		var errRes errorResponse
+		if json.Unmarshal(body, &errRes) == nil {
+			retrieveErr.ErrorCode = errRes.Error
+			retrieveErr.ErrorDescription = errRes.ErrorDescription
+		}
+		return nil, retrieveErr
+	}
+
+	var tokenRes tokenResponse
+	if err := json.Unmarshal(body, &tokenRes); err != nil {
+		return nil, fmt.Errorf("decode token response: %w", err)
+	}
+	if tokenRes.AccessToken == "" {
+		return nil, errors.New("token response has no access_token")
+	}
+	token := &oauth2.Token{
+		AccessToken: tokenRes.AccessToken,
+		TokenType:   tokenRes.TokenType,
+		ExpiresIn:   int64(tokenRes.ExpiresIn),
+	}
+	if tokenRes.ExpiresIn > 0 {
+		token.Expiry = issuedAt.Add(time.Duration(tokenRes.ExpiresIn * float64(time.Second)))
+	}
+	return token, nil
+}
@@ -0,0 +1,144 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/statisticsnorway/labid/pkg/labid/client"
+	"golang.org/x/oauth2"
+)
+
+// fakeLabid issues the subject token back as the access token.
+func fakeLabid(t *testing.T, exchanges *int) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/token" || r.Header.Get("Content-Type") != "application/x-www-form-urlencoded" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		*exchanges++
+		w.Header().Set("Content-Type", "application/json")
+		if r.PostForm.Get("subject_token") == "expired" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_request", "error_description": "token is expired"})
+			return
+		}
+		if r.PostForm.Get("scope") != "current_group,all_groups" || !slices.Equal(r.PostForm["audience"], []string{"storage", "vault"}) {
+			t.Errorf("unexpected form %v", r.PostForm)
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token":      "labid:" + r.PostForm.Get("subject_token"),
+			"issued_token_type": "urn:ietf:params:oauth:token-type:jwt",
+			"token_type":        "Bearer",
+			"expires_in":        3600,
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func saTokenFile(t *testing.T, token string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte(token), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestTokenSourceCaches(t *testing.T) {
+	var exchanges int
+	srv := fakeLabid(t, &exchanges)
+	ts := client.NewTokenSource(srv.URL,
+		client.WithServiceAccountTokenFile(saTokenFile(t, "sa-token\n")),
+		client.WithScopes("current_group", "all_groups"),
+		client.WithAudience("storage", "vault"),
+	)
+
+	for range 3 {
+		token, err := ts.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token.AccessToken != "labid:sa-token" || token.Type() != "Bearer" {
+			t.Fatalf("unexpected token %+v", token)
+		}
+		if until := time.Until(token.Expiry); until < 59*time.Minute || until > time.Hour {
+			t.Fatalf("expected token to expire in an hour, got %s", until)
+		}
+	}
+	if exchanges != 1 {
+		t.Fatalf("expected 1 exchange, got %d", exchanges)
+	}
+}
+
+func TestTokenSourceRereadsRotatedToken(t *testing.T) {
+	var exchanges int
+	srv := fakeLabid(t, &exchanges)
+	path := saTokenFile(t, "first")
+	// Refreshing more than the token lifetime before expiry exchanges every time
+	ts := client.NewTokenSource(srv.URL,
+		client.WithServiceAccountTokenFile(path),
+		client.WithScopes("current_group", "all_groups"),
+		client.WithAudience("storage", "vault"),
+		client.WithRefreshBefore(2*time.Hour),
+	)
+
+	if token, err := ts.Token(); err != nil || token.AccessToken != "labid:first" {
+		t.Fatalf("unexpected token %v, %v", token, err)
+	}
+	if err := os.WriteFile(path, []byte("second"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if token, err := ts.Token(); err != nil || token.AccessToken != "labid:second" {
+		t.Fatalf("expected rotated service account token to be used, got %v, %v", token, err)
+	}
+}
+
+func TestTokenSourceError(t *testing.T) {
+	var exchanges int
+	srv := fakeLabid(t, &exchanges)
+	ts := client.NewTokenSource(srv.URL, client.WithServiceAccountTokenFile(saTokenFile(t, "expired")))
+
+	_, err := ts.Token()
+	var retrieveErr *oauth2.RetrieveError
+	if !errors.As(err, &retrieveErr) || retrieveErr.ErrorCode != "invalid_request" || retrieveErr.ErrorDescription != "token is expired" {
+		t.Fatalf("expected invalid_request, got %v", err)
+	}
+
+	ts = client.NewTokenSource(srv.URL, client.WithServiceAccountTokenFile(filepath.Join(t.TempDir(), "missing")))
+	if _, err := ts.Token(); err == nil {
+		t.Fatal("expected error for missing service account token")
+	}
+}
+
+func TestTokenSourceTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	ts := client.NewTokenSource(srv.URL,
+		client.WithServiceAccountTokenFile(saTokenFile(t, "sa-token")),
+		client.WithTimeout(50*time.Millisecond),
+	)
+	start := time.Now()
+	if _, err := ts.Token(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected exchange to time out, took %s", elapsed)
+	}
+}
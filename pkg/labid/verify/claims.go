@@ -0,0 +1,85 @@
+package verify
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwt"
+)
+
+// Claims are the claims of a LabID token.
+type Claims struct {
+	Subject  string
+	Issuer   string
+	Audience []string
+	Expiry   time.Time
+	IssuedAt time.Time
+	Email    string
+	// Group is dapla.group, the group the service runs as, set with the
+	// current_group scope
+	Group string
+	// Groups is dapla.groups, all groups of the user, set with the
+	// all_groups scope
+	Groups []string
+	Scopes []string
+
+	token jwt.Token
+}
+
+func claimsOf(token jwt.Token) *Claims {
+	c := &Claims{token: token}
+	c.Subject, _ = token.Subject()
+	c.Issuer, _ = token.Issuer()
+	c.Audience, _ = token.Audience()
+	c.Expiry, _ = token.Expiration()
+	c.IssuedAt, _ = token.IssuedAt()
+	_ = token.Get("email", &c.Email)
+	_ = token.Get("dapla.group", &c.Group)
+
+	var groups []any
+	if err := token.Get("dapla.groups", &groups); err == nil {
+		for _, g := range groups {
+			if s, ok := g.(string); ok {
+				c.Groups = append(c.Groups, s)
+			}
+		}
+	}
+	var scope string
+	if err := token.Get("scope", &scope); err == nil && scope != "" {
+		c.Scopes = strings.Split(scope, ",")
+	}
+	return c
+}
+
+// HasScope reports whether the token was issued with scope.
+func (c *Claims) HasScope(scope string) bool {
+	return slices.Contains(c.Scopes, scope)
+}
+
+// HasGroup reports whether group is the current group or one of all groups.
+func (c *Claims) HasGroup(group string) bool {
+	return c.Group == group || slices.Contains(c.Groups, group)
+}
+
+// Get gets any other claim of the token into dst, e.g. claims from claim
+// mapping.
+func (c *Claims) Get(name string, dst any) error {
+	return c.token.Get(name, dst)
+}
+
+type claimsKey struct{}
+
+// ContextWithClaims returns a copy of ctx with claims, as done by the
+// middleware.
+func ContextWithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// ClaimsFromContext returns the claims of the token verified by the
+// middleware.
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	c, ok := ctx.Value(claimsKey{}).(*Claims)
+	return c, ok
+}
@@ -0,0 +1,70 @@
+package verify
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func (v *Verifier) authorize(ctx context.Context, r Requirements) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var rawToken string
+	for _, value := range md.Get("authorization") {
+		if t, ok := strings.CutPrefix(value, "Bearer "); ok {
+			rawToken = t
+			break
+		}
+	}
+	if rawToken == "" {
+		return nil, status.Error(codes.Unauthenticated, "bearer token is required")
+	}
+	claims, err := v.Verify(ctx, rawToken)
+	if err != nil {
+		if !errors.Is(err, ErrInvalidToken) {
+			return nil, status.Error(codes.Unavailable, "could not verify token")
+		}
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if err := r.Check(claims); err != nil {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	return ContextWithClaims(ctx, claims), nil
+}
+
+// UnaryServerInterceptor rejects calls without a bearer token in the
+// authorization metadata verified by v and meeting r, with Unauthenticated
+// or PermissionDenied. The claims are added to the context of the handler.
+func (v *Verifier) UnaryServerInterceptor(r Requirements) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := v.authorize(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streams.
+func (v *Verifier) StreamServerInterceptor(r Requirements) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := v.authorize(ss.Context(), r)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
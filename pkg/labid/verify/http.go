@@ -0,0 +1,70 @@
+package verify
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Requirements are what the middleware requires of tokens.
+type Requirements struct {
+	// Scopes must all be scopes of the token
+	Scopes []string
+	// Groups has the groups of which the token must have at least one, as
+	// either dapla.group or in dapla.groups. Empty requires no group.
+	Groups []string
+}
+
+// ErrForbidden is returned for valid tokens not meeting the requirements.
+var ErrForbidden = errors.New("token does not meet requirements")
+
+// Check returns an error wrapping ErrForbidden if claims do not meet r.
+func (r Requirements) Check(claims *Claims) error {
+	for _, s := range r.Scopes {
+		if !claims.HasScope(s) {
+			return fmt.Errorf("%w: missing scope %q", ErrForbidden, s)
+		}
+	}
+	if len(r.Groups) == 0 {
+		return nil
+	}
+	for _, g := range r.Groups {
+		if claims.HasGroup(g) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: not a member of any of %v", ErrForbidden, r.Groups)
+}
+
+// HTTPMiddleware rejects requests without a bearer token verified by v and
+// meeting r, with 401 or 403 and a WWW-Authenticate header as in RFC 6750.
+// The claims are added to the request context, see ClaimsFromContext.
+func (v *Verifier) HTTPMiddleware(r Requirements) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			rawToken, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+			if !ok {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "bearer token is required", http.StatusUnauthorized)
+				return
+			}
+			claims, err := v.Verify(req.Context(), rawToken)
+			if err != nil {
+				if !errors.Is(err, ErrInvalidToken) {
+					http.Error(w, "could not verify token", http.StatusServiceUnavailable)
+					return
+				}
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="invalid_token", error_description=%q`, err.Error()))
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			if err := r.Check(claims); err != nil {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", error_description=%q`, err.Error()))
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, req.WithContext(ContextWithClaims(req.Context(), claims)))
+		})
+	}
+}
@@ -0,0 +1,204 @@
+// Package verify validates LabID tokens, for services receiving them.
+//
+//	v, err := verify.New(ctx, "https://labid.lab.dapla.ssb.no", verify.WithAudience("my-service"))
+//	...
+//	mux.Handle("/data", v.HTTPMiddleware(verify.Requirements{
+//		Scopes: []string{"current_group"},
+//		Groups: []string{"dapla-felles-developers"},
+//	})(handler))
+//
+// The handler gets the claims of the token with ClaimsFromContext. New
+// requires the audiences of the service, so tokens meant for other services
+// are rejected, or WithAnyAudience to explicitly accept all.
+package verify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/httprc/v3"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+)
+
+var (
+	// ErrInvalidToken is wrapped by all errors of Verify, and means the token
+	// should be rejected with 401.
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrInvalidAudience is returned for tokens without an accepted audience.
+	ErrInvalidAudience = errors.New("token audience is not accepted")
+	// ErrNoAudience is returned by New when neither WithAudience nor
+	// WithAnyAudience is given.
+	ErrNoAudience = errors.New("no audience configured, use WithAudience or WithAnyAudience")
+)
+
+// Minimum time between refreshes of the JWKS for tokens signed by unknown
+// keys, so invalid tokens cannot make the verifier hammer the issuer.
+const minRefreshInterval = time.Minute
+
+// Verifier validates tokens from a LabID issuer.
+type Verifier struct {
+	issuer      string
+	audiences   []string
+	anyAudience bool
+	leeway      time.Duration
+	httpClient  *http.Client
+
+	jwksUri     string
+	jwks        *jwk.Cache
+	mu          sync.Mutex
+	lastRefresh time.Time
+}
+
+type Option func(*Verifier)
+
+// WithAudience accepts tokens with any of audiences.
+func WithAudience(audiences ...string) Option {
+	return func(v *Verifier) {
+		v.audiences = append(v.audiences, audiences...)
+	}
+}
+
+// WithAnyAudience accepts tokens regardless of their audience, which is only
+// safe if the tokens of the issuer are not meant for other services.
+func WithAnyAudience() Option {
+	return func(v *Verifier) {
+		v.anyAudience = true
+	}
+}
+
+// WithLeeway allows for clock skew when validating exp, iat and nbf. It
+// defaults to 30 seconds.
+func WithLeeway(d time.Duration) Option {
+	return func(v *Verifier) {
+		v.leeway = d
+	}
+}
+
+// WithHTTPClient sets the client for discovery and fetching keys.
+func WithHTTPClient(c *http.Client) Option {
+	return func(v *Verifier) {
+		v.httpClient = c
+	}
+}
+
+// New discovers the keys of issuer from its /.well-known/openid-configuration
+// and fetches them. The keys are cached and refreshed in the background until
+// ctx is done. It fails with ErrNoAudience unless WithAudience or
+// WithAnyAudience is given.
+func New(ctx context.Context, issuer string, opts ...Option) (*Verifier, error) {
+	v := &Verifier{
+		issuer:     strings.TrimSuffix(issuer, "/"),
+		leeway:     30 * time.Second,
+		httpClient: http.DefaultClient,
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	if len(v.audiences) == 0 && !v.anyAudience {
+		return nil, ErrNoAudience
+	}
+
+	jwksUri, err := v.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	v.jwksUri = jwksUri
+
+	v.jwks, err = jwk.NewCache(ctx, httprc.NewClient(httprc.WithHTTPClient(v.httpClient)))
+	if err != nil {
+		return nil, fmt.Errorf("create jwks cache: %w", err)
+	}
+	if err := v.jwks.Register(ctx, jwksUri); err != nil {
+		return nil, fmt.Errorf("fetch jwks from %s: %w", jwksUri, err)
+	}
+	return v, nil
+}
+
+type discoveryMetadata struct {
+	Issuer  string `json:"issuer"`
+	JwksUri string `json:"jwks_uri"`
+}
+
+func (v *Verifier) discover(ctx context.Context) (string, error) {
+	endpoint := v.issuer + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", fmt.Errorf("create discovery request: %w", err)
+	}
+	res, err := v.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("get %s: %w", endpoint, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("get %s: unexpected status %q", endpoint, res.Status)
+	}
+	var m discoveryMetadata
+	if err := json.NewDecoder(res.Body).Decode(&m); err != nil {
+		return "", fmt.Errorf("decode discovery metadata: %w", err)
+	}
+	if m.Issuer != v.issuer {
+		return "", fmt.Errorf("discovery metadata has issuer %q, expected %q", m.Issuer, v.issuer)
+	}
+	if m.JwksUri == "" {
+		return "", errors.New("discovery metadata has no jwks_uri")
+	}
+	return m.JwksUri, nil
+}
+
+// Verify validates the signature, issuer, audience and expiry of rawToken,
+// and returns its claims. Errors for tokens which should be rejected wrap
+// ErrInvalidToken, others mean the keys could not be fetched.
+func (v *Verifier) Verify(ctx context.Context, rawToken string) (*Claims, error) {
+	keys, err := v.jwks.Lookup(ctx, v.jwksUri)
+	if err != nil {
+		return nil, fmt.Errorf("get jwks: %w", err)
+	}
+	token, err := v.parse(rawToken, keys)
+	if err != nil && v.refreshAllowed() {
+		// The issuer may have rotated its keys since they were fetched
+		if keys, refreshErr := v.jwks.Refresh(ctx, v.jwksUri); refreshErr == nil {
+			token, err = v.parse(rawToken, keys)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	claims := claimsOf(token)
+	if !v.anyAudience && !slices.ContainsFunc(claims.Audience, func(aud string) bool {
+		return slices.Contains(v.audiences, aud)
+	}) {
+		return nil, fmt.Errorf("%w: %w: %v", ErrInvalidToken, ErrInvalidAudience, claims.Audience)
+	}
+	return claims, nil
+}
+
+func (v *Verifier) parse(rawToken string, keys jwk.Set) (jwt.Token, error) {
+	return jwt.Parse([]byte(rawToken),
+		jwt.WithKeySet(keys),
+		jwt.WithValidate(true),
+		jwt.WithIssuer(v.issuer),
+		jwt.WithAcceptableSkew(v.leeway),
+	)
+}
+
+// refreshAllowed reports whether the keys can be refreshed, and if so
+// records a refresh.
+func (v *Verifier) refreshAllowed() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if time.Since(v.lastRefresh) < minRefreshInterval {
+		return false
+	}
+	v.lastRefresh = time.Now()
+	return true
+}
@@ -0,0 +1,218 @@
+package verify_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+	"github.com/statisticsnorway/labid/pkg/labid/verify"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func signingKey(t *testing.T) jwk.Key {
+	t.Helper()
+	raw, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := jwk.Import(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk.AssignKeyID(key)
+	key.Set("alg", "RS256")
+	return key
+}
+
+// issuer serves discovery and keys like LabID, and signs tokens.
+type issuer struct {
+	*httptest.Server
+	key jwk.Key
+}
+
+func newIssuer(t *testing.T) *issuer {
+	t.Helper()
+	i := &issuer{key: signingKey(t)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": i.URL, "jwks_uri": i.URL + "/jwks"})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		pub, err := i.key.PublicKey()
+		if err != nil {
+			t.Error(err)
+		}
+		set := jwk.NewSet()
+		set.AddKey(pub)
+		json.NewEncoder(w).Encode(set)
+	})
+	i.Server = httptest.NewServer(mux)
+	t.Cleanup(i.Close)
+	return i
+}
+
+func (i *issuer) sign(t *testing.T, build func(*jwt.Builder)) string {
+	t.Helper()
+	b := jwt.NewBuilder().
+		Issuer(i.URL).
+		Subject("kari").
+		Audience([]string{"storage"}).
+		IssuedAt(time.Now()).
+		Expiration(time.Now().Add(time.Hour)).
+		Claim("scope", "current_group,all_groups").
+		Claim("dapla.group", "dapla-felles-developers").
+		Claim("dapla.groups", []string{"dapla-felles-developers", "play-foeniks-managers"})
+	if build != nil {
+		build(b)
+	}
+	tok, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := jwt.Sign(tok, jwt.WithKey(jwa.RS256(), i.key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(signed)
+}
+
+func TestVerify(t *testing.T) {
+	i := newIssuer(t)
+	v, err := verify.New(t.Context(), i.URL, verify.WithAudience("storage"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := v.Verify(t.Context(), i.sign(t, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "kari" || claims.Group != "dapla-felles-developers" || len(claims.Groups) != 2 ||
+		!claims.HasScope("all_groups") || !claims.HasGroup("play-foeniks-managers") {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+
+	other := newIssuer(t)
+	for name, rawToken := range map[string]string{
+		"wrong audience": i.sign(t, func(b *jwt.Builder) { b.Audience([]string{"other"}) }),
+		"expired":        i.sign(t, func(b *jwt.Builder) { b.Expiration(time.Now().Add(-time.Hour)) }),
+		"wrong issuer":   i.sign(t, func(b *jwt.Builder) { b.Issuer("https://labid.example.com") }),
+		"unknown key":    other.sign(t, func(b *jwt.Builder) { b.Issuer(i.URL) }),
+		"not a token":    "abc",
+	} {
+		if _, err := v.Verify(t.Context(), rawToken); !errors.Is(err, verify.ErrInvalidToken) {
+			t.Errorf("%s: expected ErrInvalidToken, got %v", name, err)
+		}
+	}
+}
+
+func TestVerifyRotatedKey(t *testing.T) {
+	i := newIssuer(t)
+	v, err := verify.New(t.Context(), i.URL, verify.WithAnyAudience())
+	if err != nil {
+		t.Fatal(err)
+	}
+	i.key = signingKey(t)
+	if _, err := v.Verify(t.Context(), i.sign(t, nil)); err != nil {
+		t.Fatalf("expected keys to be refreshed, got %v", err)
+	}
+}
+
+func TestNewRequiresAudience(t *testing.T) {
+	i := newIssuer(t)
+	if _, err := verify.New(t.Context(), i.URL); !errors.Is(err, verify.ErrNoAudience) {
+		t.Fatalf("expected ErrNoAudience, got %v", err)
+	}
+}
+
+func TestNewWrongIssuer(t *testing.T) {
+	i := newIssuer(t)
+	if _, err := verify.New(t.Context(), i.URL+"/other", verify.WithAnyAudience()); err == nil {
+		t.Fatal("expected discovery to fail")
+	}
+}
+
+func TestHTTPMiddleware(t *testing.T) {
+	i := newIssuer(t)
+	v, err := verify.New(t.Context(), i.URL, verify.WithAnyAudience())
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := v.HTTPMiddleware(verify.Requirements{
+		Scopes: []string{"current_group"},
+		Groups: []string{"dapla-felles-managers", "dapla-felles-developers"},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := verify.ClaimsFromContext(r.Context())
+		w.Write([]byte(claims.Subject))
+	}))
+
+	for name, tc := range map[string]struct {
+		authorization string
+		status        int
+	}{
+		"allowed":       {"Bearer " + i.sign(t, nil), http.StatusOK},
+		"no token":      {"", http.StatusUnauthorized},
+		"invalid token": {"Bearer abc", http.StatusUnauthorized},
+		"missing scope": {"Bearer " + i.sign(t, func(b *jwt.Builder) { b.Claim("scope", "all_groups") }), http.StatusForbidden},
+		"missing group": {"Bearer " + i.sign(t, func(b *jwt.Builder) {
+			b.Claim("dapla.group", "play-foeniks-developers")
+			b.Claim("dapla.groups", []string{})
+		}), http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tc.authorization != "" {
+			req.Header.Set("Authorization", tc.authorization)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Errorf("%s: expected %d, got %d: %s", name, tc.status, rec.Code, rec.Body)
+		}
+		if rec.Code != http.StatusOK && rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: expected WWW-Authenticate header", name)
+		}
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	i := newIssuer(t)
+	v, err := verify.New(t.Context(), i.URL, verify.WithAnyAudience())
+	if err != nil {
+		t.Fatal(err)
+	}
+	intercept := v.UnaryServerInterceptor(verify.Requirements{Groups: []string{"play-foeniks-managers"}})
+	handler := func(ctx context.Context, _ any) (any, error) {
+		claims, ok := verify.ClaimsFromContext(ctx)
+		if !ok {
+			return nil, errors.New("no claims in context")
+		}
+		return claims.Subject, nil
+	}
+	call := func(authorization string) (any, error) {
+		ctx := metadata.NewIncomingContext(t.Context(), metadata.Pairs("authorization", authorization))
+		return intercept(ctx, nil, &grpc.UnaryServerInfo{}, handler)
+	}
+
+	if res, err := call("Bearer " + i.sign(t, nil)); err != nil || res != "kari" {
+		t.Fatalf("expected kari, got %v, %v", res, err)
+	}
+	if _, err := call("Bearer abc"); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated, got %v", err)
+	}
+	noGroups := i.sign(t, func(b *jwt.Builder) { b.Claim("dapla.groups", []string{}) })
+	if _, err := call("Bearer " + noGroups); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied, got %v", err)
+	}
+}